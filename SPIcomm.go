@@ -12,10 +12,28 @@ func (e CustomError) Error() string {
 	return string(e)
 }
 
+// SPI status flags returned by the Driver in the first byte of every SPI datagram
+const (
+	SPIStatusResetFlag       = 1 << 0 // GSTAT reset flag
+	SPIStatusDriverError     = 1 << 1 // GSTAT drv_err flag
+	SPIStatusSG2             = 1 << 2 // DRV_STATUS stallGuard flag
+	SPIStatusStandstill      = 1 << 3 // DRV_STATUS standstill flag
+	SPIStatusVelocityReached = 1 << 4 // RAMP_STAT velocity_reached flag
+	SPIStatusPositionReached = 1 << 5 // RAMP_STAT position_reached flag
+	SPIStatusStopL           = 1 << 6 // RAMP_STAT status_stop_l flag
+	SPIStatusStopR           = 1 << 7 // RAMP_STAT status_stop_r flag
+)
+
+// StatusComm is implemented by communication interfaces that receive the SPI status byte with every access
+type StatusComm interface {
+	Status(driverAddress uint8) uint8
+}
+
 // SPIComm implements RegisterComm for SPI-based communication
 type SPIComm struct {
 	spi    machine.SPI
 	CsPins map[uint8]machine.Pin // Map to store CS pin for each Driver by its address
+	status map[uint8]uint8       // Last SPI status byte received from each Driver
 }
 
 // NewSPIComm creates a new SPIComm instance.
@@ -64,11 +82,12 @@ func (comm *SPIComm) WriteRegister(register uint8, value uint32, driverAddress u
 	addressWithWriteAccess := register | 0x80
 
 	// Send the address and the data to write (split into 4 bytes)
-	_, err := spiTransfer40(&comm.spi, addressWithWriteAccess, value)
+	status, _, err := spiTransfer40(&comm.spi, addressWithWriteAccess, value)
 	if err != nil {
 		csPin.High()
 		return CustomError("Failed to write register")
 	}
+	comm.setStatus(driverAddress, status)
 
 	// Deassert the chip select pin (set CS high to end communication)
 	csPin.High()
@@ -86,7 +105,7 @@ func (comm *SPIComm) ReadRegister(register uint8, driverAddress uint8) (uint32,
 	csPin.Low()
 
 	// Step 1: Send a dummy write operation to begin the read sequence
-	_, err := spiTransfer40(&comm.spi, register, 0x00) // Send dummy data
+	_, _, err := spiTransfer40(&comm.spi, register, 0x00) // Send dummy data
 	if err != nil {
 		csPin.High()
 		return 0, CustomError("Failed to send dummy write")
@@ -95,11 +114,12 @@ func (comm *SPIComm) ReadRegister(register uint8, driverAddress uint8) (uint32,
 	time.Sleep(176 * time.Nanosecond)
 	csPin.Low()
 	// Step 2: Send the register read request again to get the actual value
-	response, err := spiTransfer40(&comm.spi, register, 0x00) // Send again to get actual register data
+	status, response, err := spiTransfer40(&comm.spi, register, 0x00) // Send again to get actual register data
 	if err != nil {
 		csPin.High()
 		return 0, CustomError("Failed to read register")
 	}
+	comm.setStatus(driverAddress, status)
 
 	// Deassert the chip select pin (set CS high to end communication)
 	csPin.High()
@@ -107,7 +127,19 @@ func (comm *SPIComm) ReadRegister(register uint8, driverAddress uint8) (uint32,
 	return response, nil
 }
 
-func spiTransfer40(spi *machine.SPI, register uint8, txData uint32) (uint32, error) {
+// Status returns the SPI status byte received with the last access to the Driver (see SPIStatus flags)
+func (comm *SPIComm) Status(driverAddress uint8) uint8 {
+	return comm.status[driverAddress]
+}
+
+func (comm *SPIComm) setStatus(driverAddress uint8, status uint8) {
+	if comm.status == nil {
+		comm.status = make(map[uint8]uint8)
+	}
+	comm.status[driverAddress] = status
+}
+
+func spiTransfer40(spi *machine.SPI, register uint8, txData uint32) (uint8, uint32, error) {
 	// Prepare the 5-byte buffer for transmission (1 byte address + 4 bytes data)
 	tx := []byte{
 		register,           // Address byte
@@ -122,11 +154,11 @@ func spiTransfer40(spi *machine.SPI, register uint8, txData uint32) (uint32, err
 	// Perform the SPI transaction
 	err := spi.Tx(tx, rx)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 	//println("Received", rx[0], rx[1], rx[2], rx[3], rx[4])
-	// Combine the received bytes into a 32-bit response, ignore the address byte
+	// Combine the received bytes into a 32-bit response, the first byte is the SPI status
 	rxData := uint32(rx[1])<<24 | uint32(rx[2])<<16 | uint32(rx[3])<<8 | uint32(rx[4])
 
-	return rxData, nil
+	return rx[0], rxData, nil
 }
@@ -41,6 +41,7 @@ const (
 	//Attention:  Do  not  set  0  in  positioning  mode, minimum 10 recommend!
 	TZEROWAIT = 0x2C // Waiting time after ramping down to zero velocity before next movement or direction inversion can start.
 	XTARGET   = 0x2D // Target position for ramp mode
+	XDIRECT   = 0x2D // Coil currents in direct mode, shares the address of XTARGET
 
 	/* Ramp generator driver feature control registers */
 	VDCMIN    = 0x33 // Velocity threshold for enabling automatic commutation dcStep
@@ -0,0 +1,146 @@
+package tmc5160
+
+import (
+	"github.com/orsinium-labs/tinymath"
+)
+
+// Axis expresses positions and speeds of a mechanism driven by a Stepper in application units,
+// e.g. millimetres for lead screws and belts or degrees for rotary axes.
+// Positions are in units, velocities in units/s and accelerations in units/s².
+type Axis struct {
+	Stepper     *Stepper // Motor of the axis, the defaults of a zero Stepper if nil
+	UnitsPerRev float32  // Travel in units per revolution of the output shaft (0 = motor full steps)
+}
+
+// NewLeadScrewAxis creates a linear axis in millimetres driven by a lead screw with the given pitch (lead) in mm
+func NewLeadScrewAxis(stepper *Stepper, pitch float32) Axis {
+	return Axis{Stepper: stepper, UnitsPerRev: pitch}
+}
+
+// NewBeltAxis creates a linear axis in millimetres driven by a belt pulley with the given teeth and belt pitch in mm
+func NewBeltAxis(stepper *Stepper, pulleyTeeth uint16, beltPitch float32) Axis {
+	return Axis{Stepper: stepper, UnitsPerRev: float32(pulleyTeeth) * beltPitch}
+}
+
+// NewRotaryAxis creates a rotary axis in degrees of the output shaft
+func NewRotaryAxis(stepper *Stepper) Axis {
+	return Axis{Stepper: stepper, UnitsPerRev: 360}
+}
+
+// NewRevolutionAxis creates a rotary axis in revolutions of the output shaft
+func NewRevolutionAxis(stepper *Stepper) Axis {
+	return Axis{Stepper: stepper, UnitsPerRev: 1}
+}
+
+// zeroStepper stands in for a missing Stepper, e.g. in a Driver not created with NewDriver
+var zeroStepper Stepper
+
+// stepper returns the Stepper of the axis, or the defaults of a zero Stepper if it is not set
+func (axis *Axis) stepper() *Stepper {
+	if axis.Stepper == nil {
+		return &zeroStepper
+	}
+	return axis.Stepper
+}
+
+// StepsPerUnit returns the number of motor full steps per axis unit, including the gear ratio
+func (axis *Axis) StepsPerUnit() float32 {
+	if axis.UnitsPerRev == 0 {
+		return 1
+	}
+	angle := axis.stepper().Angle
+	if angle == 0 {
+		angle = DefaultAngle
+	}
+	gearRatio := axis.stepper().GearRatio
+	if gearRatio == 0 {
+		gearRatio = DefaultGearRatio
+	}
+	return 360 / angle * gearRatio / axis.UnitsPerRev
+}
+
+// ToSteps converts a distance in axis units into motor full steps
+func (axis *Axis) ToSteps(units float32) float32 {
+	return units * axis.StepsPerUnit()
+}
+
+// FromSteps converts motor full steps into a distance in axis units
+func (axis *Axis) FromSteps(steps float32) float32 {
+	return steps / axis.StepsPerUnit()
+}
+
+// ToMicrosteps converts a position in axis units into microsteps, rounded to the nearest microstep
+func (axis *Axis) ToMicrosteps(position float32) int32 {
+	return int32(tinymath.Round(axis.ToSteps(position) * axis.stepper().microsteps()))
+}
+
+// FromMicrosteps converts a position in microsteps into axis units
+func (axis *Axis) FromMicrosteps(microsteps int32) float32 {
+	return axis.FromSteps(float32(microsteps) / axis.stepper().microsteps())
+}
+
+// PositionToXACTUAL converts a position in axis units into an XACTUAL (or XTARGET) register value
+func (axis *Axis) PositionToXACTUAL(position float32) uint32 {
+	return MicrostepsToXACTUAL(axis.ToMicrosteps(position))
+}
+
+// XACTUALToPosition converts an XACTUAL (or XTARGET, XLATCH) register value into a position in axis units
+func (axis *Axis) XACTUALToPosition(xactual uint32) float32 {
+	return axis.FromMicrosteps(XACTUALToMicrosteps(xactual))
+}
+
+// VelocityToVMAX converts a velocity in units/s into a VMAX register value
+func (axis *Axis) VelocityToVMAX(v float32) uint32 {
+	return axis.stepper().VelocityToVMAX(axis.ToSteps(v))
+}
+
+// VMAXToVelocity converts a VMAX register value into a velocity in units/s
+func (axis *Axis) VMAXToVelocity(vmax uint32) float32 {
+	return axis.FromSteps(axis.stepper().VMAXToVelocity(vmax))
+}
+
+// VACTUALToVelocity converts a VACTUAL register value into a signed velocity in units/s
+func (axis *Axis) VACTUALToVelocity(vactual uint32) float32 {
+	return axis.FromSteps(axis.stepper().VACTUALToVelocity(vactual))
+}
+
+// AccelerationToAMAX converts an acceleration in units/s² into an AMAX (or A1, DMAX, D1) register value
+func (axis *Axis) AccelerationToAMAX(a float32) uint32 {
+	return axis.stepper().AccelerationToAMAX(axis.ToSteps(a))
+}
+
+// AMAXToAcceleration converts an AMAX register value into an acceleration in units/s²
+func (axis *Axis) AMAXToAcceleration(amax uint32) float32 {
+	return axis.FromSteps(axis.stepper().AMAXToAcceleration(amax))
+}
+
+// VelocityToTSTEP converts a velocity in units/s into TSTEP units, as used by TPWMTHRS, TCOOLTHRS and THIGH
+func (axis *Axis) VelocityToTSTEP(v float32) uint32 {
+	return axis.stepper().VelocityToTSTEP(axis.ToSteps(v))
+}
+
+// RampToSteps converts a ramp profile in axis units into full steps, ready for Driver.SetRamp
+func (axis *Axis) RampToSteps(profile RampProfile) RampProfile {
+	return RampProfile{
+		VStart: axis.ToSteps(profile.VStart),
+		A1:     axis.ToSteps(profile.A1),
+		V1:     axis.ToSteps(profile.V1),
+		AMax:   axis.ToSteps(profile.AMax),
+		VMax:   axis.ToSteps(profile.VMax),
+		DMax:   axis.ToSteps(profile.DMax),
+		D1:     axis.ToSteps(profile.D1),
+		VStop:  axis.ToSteps(profile.VStop),
+	}
+}
+
+// SetAxis sets the mechanical model used by the Driver's motion functions.
+// The axis is bound to the Driver's own Stepper so that later changes to it are taken into account.
+func (driver *Driver) SetAxis(axis Axis) {
+	axis.Stepper = &driver.stepper
+	driver.axis = axis
+}
+
+// Axis returns the mechanical model used by the Driver
+func (driver *Driver) Axis() *Axis {
+	return &driver.axis
+}
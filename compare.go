@@ -0,0 +1,127 @@
+package tmc5160
+
+// SetPositionCompare makes DIAG1 pulse when XACTUAL equals position (in axis units), e.g. to trigger a camera.
+// The other DIAG1 functions are turned off in GCONF. With pushPull DIAG1 is an active high push-pull output,
+// otherwise an active low open collector output.
+func (driver *Driver) SetPositionCompare(position float32, pushPull bool) error {
+	gconf := NewGCONF()
+	gconf.Unpack(driver.shadowValue(GCONF))
+	gconf.Diag1StallDir = false
+	gconf.Diag1Index = false
+	gconf.Diag1Onstate = false
+	gconf.Diag1StepsSkipped = false
+	gconf.Diag1PosCompPushPull = pushPull
+	if err := driver.WriteRegister(GCONF, gconf.Pack()); err != nil {
+		return err
+	}
+	return driver.WriteRegister(X_COMPARE, driver.axis.PositionToXACTUAL(position))
+}
+
+// CompareSequence configures RunCompareSequence
+type CompareSequence struct {
+	Start    float32     // First compare position in axis units
+	Step     float32     // Distance between compare positions in axis units, negative for decreasing positions
+	Count    int         // Number of compare positions
+	PushPull bool        // DIAG1 output type, see SetPositionCompare
+	Wait     WaitOptions // Polling interval, timeout and cancellation
+}
+
+// RunCompareSequence pulses DIAG1 at Count equally spaced positions. X_COMPARE is set to the next position each time
+// XACTUAL has passed the current one, so the moves must be slow enough for the polling interval to re-arm it in time.
+// It returns the number of positions passed when all were passed or the move ended in positioning mode.
+func (driver *Driver) RunCompareSequence(seq CompareSequence) (int, error) {
+	if seq.Count <= 0 || (seq.Step == 0 && seq.Count > 1) {
+		return 0, CustomError("invalid compare sequence")
+	}
+	if err := driver.SetPositionCompare(seq.Start, seq.PushPull); err != nil {
+		return 0, err
+	}
+	hits := 0
+	target := driver.axis.ToMicrosteps(seq.Start)
+	step := driver.axis.ToMicrosteps(seq.Step)
+	var writeErr error
+	err := driver.watchPosition(func(prev, cur int32) bool {
+		passed := hits
+		for hits < seq.Count && crossed(prev, cur, target) {
+			hits++
+			target += step
+		}
+		if hits == seq.Count || hits == passed {
+			return hits == seq.Count
+		}
+		writeErr = driver.WriteRegister(X_COMPARE, MicrostepsToXACTUAL(target))
+		return writeErr != nil
+	}, seq.Wait)
+	if err == nil {
+		err = writeErr
+	}
+	return hits, err
+}
+
+// crossed reports whether a position was reached when moving from prev to cur
+func crossed(prev, cur, position int32) bool {
+	return (prev < position && position <= cur) || (cur <= position && position < prev)
+}
+
+// watchPosition polls XACTUAL and passes the previous and current position to update until it returns true,
+// the move ends in positioning mode, a stop or fault event occurs, the timeout expires or opts.Done is closed
+func (driver *Driver) watchPosition(update func(prev, cur int32) bool, opts WaitOptions) error {
+	prev, err := driver.readPosition()
+	if err != nil {
+		return err
+	}
+	var readErr error
+	err = driver.waitFor(func(rampStat *RAMP_STAT_Register) bool {
+		var cur int32
+		if cur, readErr = driver.readPosition(); readErr != nil {
+			return true
+		}
+		done := update(prev, cur)
+		prev = cur
+		return done || (driver.rampMode == PositioningMode && rampStat.PositionReached)
+	}, opts)
+	if err != nil {
+		return err
+	}
+	return readErr
+}
+
+// PositionTrigger calls functions when XACTUAL crosses registered positions during a move
+type PositionTrigger struct {
+	driver *Driver
+	points []triggerPoint
+}
+
+type triggerPoint struct {
+	position int32 // Microsteps
+	fn       func(position float32)
+}
+
+// NewPositionTrigger creates a PositionTrigger for the Driver's axis
+func (driver *Driver) NewPositionTrigger() *PositionTrigger {
+	return &PositionTrigger{driver: driver}
+}
+
+// Add registers fn to be called with the position (in axis units) when XACTUAL crosses it in either direction
+func (trigger *PositionTrigger) Add(position float32, fn func(position float32)) {
+	trigger.points = append(trigger.points, triggerPoint{position: trigger.driver.axis.ToMicrosteps(position), fn: fn})
+}
+
+// Clear removes all registered positions
+func (trigger *PositionTrigger) Clear() {
+	trigger.points = trigger.points[:0]
+}
+
+// Watch polls XACTUAL and calls the functions of the positions crossed since the previous poll.
+// In positioning mode it returns when the target is reached; in velocity mode it runs until opts times out
+// or is canceled. Positions are detected with the polling interval, the callbacks run on the caller's goroutine.
+func (trigger *PositionTrigger) Watch(opts WaitOptions) error {
+	return trigger.driver.watchPosition(func(prev, cur int32) bool {
+		for _, point := range trigger.points {
+			if crossed(prev, cur, point.position) {
+				point.fn(trigger.driver.axis.FromMicrosteps(point.position))
+			}
+		}
+		return false
+	}, opts)
+}
@@ -0,0 +1,118 @@
+package tmc5160
+
+import (
+	"github.com/orsinium-labs/tinymath"
+)
+
+// Register limits of the ramp generator
+const (
+	maxVSTART  = 0x3FFFF  // VSTART: 18 bits
+	maxVSTOP   = 0x3FFFF  // VSTOP: 18 bits
+	maxV1      = 0xFFFFF  // V1: 20 bits
+	maxAccel   = 0xFFFF   // A1, AMAX, DMAX, D1: 16 bits
+	maxTSTEP   = 0xFFFFF  // TSTEP, TPWMTHRS, TCOOLTHRS: 20 bits
+	maxVACTUAL = 0x7FFFFF // VACTUAL: 24 bits signed
+	minVSTOP   = 10       // Smallest recommended VSTOP in positioning mode
+)
+
+// fclk returns the driver clock in Hz, falling back to the internal 12MHz clock when Fclk is not set
+func (stepper *Stepper) fclk() float32 {
+	if stepper.Fclk == 0 {
+		return DEFAULT_F_CLK
+	}
+	return float32(stepper.Fclk) * 1000000
+}
+
+// microsteps returns the microstep resolution as a float, treating an unset resolution as fullstepping
+func (stepper *Stepper) microsteps() float32 {
+	if stepper.MSteps == 0 {
+		return 1
+	}
+	return float32(stepper.MSteps)
+}
+
+// velocityToRegister converts a velocity in full steps/s into the unclamped ramp generator velocity unit.
+// v[register] = v[µsteps/s] * 2^24 / fCLK
+func (stepper *Stepper) velocityToRegister(v float32) float32 {
+	return tinymath.Round(v * stepper.microsteps() * 16777216 / stepper.fclk())
+}
+
+// accelerationToRegister converts an acceleration in full steps/s² into the unclamped ramp generator acceleration unit.
+// a[register] = a[µsteps/s²] * 2^41 / fCLK²
+func (stepper *Stepper) accelerationToRegister(a float32) float32 {
+	fclk := stepper.fclk()
+	return tinymath.Round(a * stepper.microsteps() * (2199023255552 / fclk) / fclk)
+}
+
+// VelocityToVMAX converts a velocity in full steps/s into a VMAX register value
+func (stepper *Stepper) VelocityToVMAX(v float32) uint32 {
+	return uint32(constrain(stepper.velocityToRegister(v), 0, maxVMAX))
+}
+
+// AccelerationToAMAX converts an acceleration in full steps/s² into an AMAX (or A1, DMAX, D1) register value
+func (stepper *Stepper) AccelerationToAMAX(a float32) uint32 {
+	return uint32(constrain(stepper.accelerationToRegister(a), 0, maxAccel))
+}
+
+// VMAXToVelocity converts a VMAX (or VSTART, V1, VSTOP) register value into a velocity in full steps/s
+func (stepper *Stepper) VMAXToVelocity(vmax uint32) float32 {
+	return float32(vmax) * stepper.fclk() / 16777216 / stepper.microsteps()
+}
+
+// VelocityToVACTUAL converts a signed velocity in full steps/s into the 24-bit two's complement VACTUAL format
+func (stepper *Stepper) VelocityToVACTUAL(v float32) uint32 {
+	r := constrain(stepper.velocityToRegister(v), -maxVACTUAL, maxVACTUAL)
+	return uint32(int32(r)) & 0xFFFFFF
+}
+
+// VACTUALToVelocity converts a VACTUAL register value into a signed velocity in full steps/s
+func (stepper *Stepper) VACTUALToVelocity(vactual uint32) float32 {
+	signed := int32(vactual<<8) >> 8 // Sign extend the 24-bit value
+	return float32(signed) * stepper.fclk() / 16777216 / stepper.microsteps()
+}
+
+// AMAXToAcceleration converts an AMAX (or A1, DMAX, D1) register value into an acceleration in full steps/s²
+func (stepper *Stepper) AMAXToAcceleration(amax uint32) float32 {
+	fclk := stepper.fclk()
+	return float32(amax) * fclk / 2199023255552 * fclk / stepper.microsteps()
+}
+
+// VelocityToTSTEP converts a velocity in full steps/s into TSTEP units, as used by TPWMTHRS, TCOOLTHRS and THIGH.
+// TSTEP is the time between two 1/256 microsteps in clock cycles, so it does not depend on MSteps.
+// A velocity of 0 gives the largest TSTEP value.
+func (stepper *Stepper) VelocityToTSTEP(v float32) uint32 {
+	if v <= 0 {
+		return maxTSTEP
+	}
+	r := tinymath.Round(stepper.fclk() / (256 * v))
+	return uint32(constrain(r, 0, maxTSTEP))
+}
+
+// TSTEPToVelocity converts a TSTEP (or TPWMTHRS, TCOOLTHRS, THIGH) value into a velocity in full steps/s.
+// 0 and the overflow value 2^20-1 both read as standstill.
+func (stepper *Stepper) TSTEPToVelocity(tstep uint32) float32 {
+	if tstep == 0 || tstep >= maxTSTEP {
+		return 0
+	}
+	return stepper.fclk() / (256 * float32(tstep))
+}
+
+// FullstepsToXACTUAL converts a position in full steps into an XACTUAL (or XTARGET) register value
+func (stepper *Stepper) FullstepsToXACTUAL(steps float32) uint32 {
+	return MicrostepsToXACTUAL(int32(tinymath.Round(steps * stepper.microsteps())))
+}
+
+// XACTUALToFullsteps converts an XACTUAL (or XTARGET, XLATCH) register value into a position in full steps
+func (stepper *Stepper) XACTUALToFullsteps(xactual uint32) float32 {
+	return float32(XACTUALToMicrosteps(xactual)) / stepper.microsteps()
+}
+
+// MicrostepsToXACTUAL converts a signed position in microsteps into the two's complement XACTUAL format
+func MicrostepsToXACTUAL(microsteps int32) uint32 {
+	return uint32(microsteps)
+}
+
+// XACTUALToMicrosteps converts an XACTUAL register value into a signed position in microsteps
+func XACTUALToMicrosteps(xactual uint32) int32 {
+	return int32(xactual)
+}
@@ -0,0 +1,99 @@
+package tmc5160
+
+// CoolStepResponse selects how fast coolStep adapts the motor current to the load
+type CoolStepResponse uint8
+
+const (
+	CoolStepSlow     CoolStepResponse = iota // Current +1 per stallGuard2 measurement, -1 per 32 measurements
+	CoolStepModerate                         // Current +2 per measurement, -1 per 8 measurements
+	CoolStepFast                             // Current +4 per measurement, -1 per 2 measurements
+	CoolStepFastest                          // Current +8 per measurement, -1 per measurement
+)
+
+// CoolStep configures coolStep load adaptive current control.
+// Load thresholds are stallGuard2 SG_RESULT values (0..1023, lower = more load), as reported by TuneStallGuard;
+// they are rounded to the hardware resolution of 32.
+type CoolStep struct {
+	MinCurrent    float32          // Lowest current as a fraction of IRUN: 0.5, or 0.25 for values below 0.5
+	IncreaseBelow uint16           // Raise the current while SG_RESULT is below this value (32..480)
+	DecreaseAbove uint16           // Lower the current while SG_RESULT is at or above this value
+	Response      CoolStepResponse // Reaction speed to load changes
+	MinSpeed      float32          // coolStep is active above this velocity in units/s (TCOOLTHRS, 0 = all velocities)
+}
+
+// CoolStepStatus is a snapshot of the coolStep current control
+type CoolStepStatus struct {
+	CsActual uint8   // Actual current scale (0..31)
+	Scale    float32 // CsActual as a fraction of IRUN
+	SgResult uint16  // stallGuard2 load value
+}
+
+// SetCoolStep enables coolStep with the given configuration.
+// SGT and the stallGuard2 filter in COOLCONF are kept. coolStep only works in spreadCycle
+// and below THIGH, see SetModeThresholds.
+func (driver *Driver) SetCoolStep(cfg CoolStep) error {
+	if cfg.MinSpeed < 0 {
+		return CustomError("speed must not be negative")
+	}
+	if cfg.Response > CoolStepFastest {
+		return CustomError("invalid coolStep response")
+	}
+	semin := (cfg.IncreaseBelow + 31) / 32
+	if semin < 1 || semin > 15 {
+		return CustomError("coolStep lower threshold out of range (32..480)")
+	}
+	if cfg.DecreaseAbove <= cfg.IncreaseBelow {
+		return CustomError("coolStep upper threshold must be above the lower threshold")
+	}
+	// Current is decreased while SG_RESULT >= (SEMIN + SEMAX + 1) * 32
+	semax := constrain(int(cfg.DecreaseAbove/32)-int(semin)-1, 0, 15)
+
+	coolconf := NewCOOLCONF()
+	coolconf.Unpack(driver.shadowValue(COOLCONF))
+	coolconf.Semin = uint8(semin)
+	coolconf.Semax = uint8(semax)
+	coolconf.Seup = uint8(cfg.Response)
+	coolconf.Sedn = uint8(cfg.Response)
+	coolconf.Seimin = cfg.MinCurrent < 0.5
+	if err := driver.WriteRegister(TCOOLTHRS, driver.axis.VelocityToTSTEP(cfg.MinSpeed)); err != nil {
+		return err
+	}
+	return driver.WriteRegister(COOLCONF, coolconf.Pack())
+}
+
+// DisableCoolStep turns coolStep off, keeping the stallGuard2 settings
+func (driver *Driver) DisableCoolStep() error {
+	coolconf := NewCOOLCONF()
+	coolconf.Unpack(driver.shadowValue(COOLCONF))
+	coolconf.Semin = 0
+	return driver.WriteRegister(COOLCONF, coolconf.Pack())
+}
+
+// CoolStepStatus reads the actual current scale and load from DRV_STATUS
+func (driver *Driver) CoolStepStatus() (CoolStepStatus, error) {
+	value, err := driver.ReadRegister(DRV_STATUS)
+	if err != nil {
+		return CoolStepStatus{}, err
+	}
+	drvStatus := NewDRV_STATUS()
+	drvStatus.Unpack(value)
+	iholdrun := NewIHOLD_IRUN()
+	iholdrun.Unpack(driver.shadowValue(IHOLD_IRUN))
+	return CoolStepStatus{
+		CsActual: drvStatus.CsActual,
+		Scale:    float32(drvStatus.CsActual+1) / float32(iholdrun.Irun+1),
+		SgResult: drvStatus.SgResult,
+	}, nil
+}
+
+// MonitorCoolStep reads CoolStepStatus every opts.Interval and passes it to report until report returns false
+// (nil is returned), the timeout expires (ErrWaitTimeout) or opts.Done is closed (ErrWaitCanceled)
+func (driver *Driver) MonitorCoolStep(opts WaitOptions, report func(CoolStepStatus) bool) error {
+	return poll(opts, func() (bool, error) {
+		status, err := driver.CoolStepStatus()
+		if err != nil {
+			return false, err
+		}
+		return !report(status), nil
+	})
+}
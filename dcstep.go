@@ -0,0 +1,68 @@
+package tmc5160
+
+// Blank time in clock cycles for each CHOPCONF.Tbl setting
+var blankTimeClocks = [4]uint16{16, 24, 36, 54}
+
+// DcStep configures dcStep load dependent speed control
+type DcStep struct {
+	MinSpeed float32 // dcStep is active above this velocity in units/s (VDCMIN, also used as THIGH)
+	Velocity float32 // Expected velocity under load in units/s, for step loss detection (0 = detection off)
+}
+
+// SetDcStep enables dcStep above cfg.MinSpeed.
+// DC_TIME is set slightly above the blank time configured in CHOPCONF. DC_SG limits the PWM on time to a
+// quarter of a fullstep at the expected velocity, but at least slightly above DC_TIME/16 as the datasheet recommends.
+// dcStep runs in fullstep mode, so THIGH is set to the same velocity and the CHOPCONF high velocity switches are enabled.
+func (driver *Driver) SetDcStep(cfg DcStep) error {
+	if cfg.MinSpeed <= 0 {
+		return CustomError("dcStep speed must be greater than 0")
+	}
+	if cfg.Velocity < 0 {
+		return CustomError("speed must not be negative")
+	}
+	chopconf := NewCHOPCONF()
+	chopconf.Unpack(driver.shadowValue(CHOPCONF))
+
+	dcctrl := NewDCCTRL()
+	dcctrl.DcTime = blankTimeClocks[chopconf.Tbl&0x3] + 1
+	if cfg.Velocity > 0 {
+		minDcSg := uint32(dcctrl.DcTime)/16 + 1
+		fullstep := driver.stepper.fclk() / driver.axis.ToSteps(cfg.Velocity) // Clock cycles per fullstep
+		dcctrl.DcSg = uint8(constrain(uint32(fullstep/4/16), minDcSg, 0xFF))
+	}
+	if err := driver.WriteRegister(DCCTRL, dcctrl.Pack()); err != nil {
+		return err
+	}
+
+	thigh := NewTHIGH()
+	thigh.Value = driver.axis.VelocityToTSTEP(cfg.MinSpeed)
+	if err := driver.WriteRegister(THIGH, thigh.Pack()); err != nil {
+		return err
+	}
+	chopconf.Vhighfs = true
+	chopconf.Vhighchm = true
+	if err := driver.WriteRegister(CHOPCONF, chopconf.Pack()); err != nil {
+		return err
+	}
+	vdcmin := NewVDCMIN()
+	vdcmin.Value = driver.axis.VelocityToVMAX(cfg.MinSpeed)
+	return driver.WriteRegister(VDCMIN, vdcmin.Pack())
+}
+
+// DisableDcStep turns dcStep off. THIGH and the CHOPCONF high velocity switches are left as they are.
+func (driver *Driver) DisableDcStep() error {
+	return driver.WriteRegister(VDCMIN, 0)
+}
+
+// LostSteps returns the number of steps skipped by dcStep, counting up or down with the direction.
+// The counter wraps around at 2^20 and only counts in STEP/DIR mode, when the step input does not stop while DC_OUT is low;
+// with the internal ramp generator, dcStep slows the ramp down instead.
+func (driver *Driver) LostSteps() (int32, error) {
+	value, err := driver.ReadRegister(LOST_STEPS)
+	if err != nil {
+		return 0, err
+	}
+	lostSteps := NewLOST_STEPS()
+	lostSteps.Unpack(value)
+	return int32(lostSteps.Value<<12) >> 12, nil
+}
@@ -0,0 +1,124 @@
+package tmc5160
+
+// ErrEncoderDeviation is wrapped by the DeviationError returned when XACTUAL and the encoder drift apart
+const ErrEncoderDeviation = CustomError("encoder deviation")
+
+// DeviationError reports a deviation between XACTUAL and the encoder detected by SuperviseEncoder
+type DeviationError struct {
+	Position float32 // XACTUAL in axis units when the deviation was detected
+	Encoder  float32 // X_ENC in axis units when the deviation was detected
+	Resynced bool    // XACTUAL was set to the encoder position after stopping
+}
+
+func (e *DeviationError) Error() string {
+	return ErrEncoderDeviation.Error()
+}
+
+// Unwrap allows errors.Is(err, ErrEncoderDeviation)
+func (e *DeviationError) Unwrap() error {
+	return ErrEncoderDeviation
+}
+
+// Deviation returns the encoder position minus XACTUAL in axis units
+func (e *DeviationError) Deviation() float32 {
+	return e.Encoder - e.Position
+}
+
+// EncoderSupervision configures SuperviseEncoder
+type EncoderSupervision struct {
+	HardStop bool        // Stop with the maximum deceleration instead of the configured acceleration
+	Resync   bool        // Set XACTUAL (and XTARGET) to the encoder position once stopped
+	Wait     WaitOptions // Polling interval, timeout and cancellation of the supervision
+}
+
+// SetEncoderDeviation sets the maximum deviation in axis units between XACTUAL and the encoder
+// before ENC_STATUS.DeviationWarn is raised, and clears a pending warning. 0 turns the check off.
+func (driver *Driver) SetEncoderDeviation(deviation float32) error {
+	if deviation < 0 {
+		return CustomError("deviation must not be negative")
+	}
+	encDeviation := NewENC_DEVIATION()
+	encDeviation.Value = uint32(driver.axis.ToMicrosteps(deviation))
+	if err := driver.WriteRegister(ENC_DEVIATION, encDeviation.Pack()); err != nil {
+		return err
+	}
+	return driver.clearDeviationWarning()
+}
+
+// clearDeviationWarning clears ENC_STATUS.DeviationWarn, which is cleared by writing 1
+func (driver *Driver) clearDeviationWarning() error {
+	encStatus := NewENC_STATUS()
+	encStatus.DeviationWarn = true
+	return driver.WriteRegister(ENC_STATUS, encStatus.Pack())
+}
+
+// CheckDeviation returns a DeviationError if the driver flagged a deviation beyond the ENC_DEVIATION limit
+func (driver *Driver) CheckDeviation() error {
+	value, err := driver.ReadRegister(ENC_STATUS)
+	if err != nil {
+		return err
+	}
+	encStatus := NewENC_STATUS()
+	encStatus.Unpack(value)
+	if !encStatus.DeviationWarn {
+		return nil
+	}
+	xactual, err := driver.readPosition()
+	if err != nil {
+		return err
+	}
+	xenc, err := driver.readEncoder()
+	if err != nil {
+		return err
+	}
+	return &DeviationError{Position: driver.axis.FromMicrosteps(xactual), Encoder: driver.axis.FromMicrosteps(xenc)}
+}
+
+// CheckEncoder checks once for a deviation between the encoder and XACTUAL beyond the limit set with
+// SetEncoderDeviation. On a deviation the motor is stopped and, with cfg.Resync, XACTUAL is set to the encoder
+// position so that subsequent moves start from where the motor really is; the deviation is returned as a
+// *DeviationError. Call it from the control loop between motion commands to supervise a move.
+func (driver *Driver) CheckEncoder(cfg EncoderSupervision) error {
+	err := driver.CheckDeviation()
+	if deviation, ok := err.(*DeviationError); ok {
+		return driver.recoverDeviation(deviation, cfg)
+	}
+	return err
+}
+
+// SuperviseEncoder calls CheckEncoder every cfg.Wait.Interval until a deviation is found.
+// ErrWaitTimeout or ErrWaitCanceled end the supervision without an event.
+func (driver *Driver) SuperviseEncoder(cfg EncoderSupervision) error {
+	return poll(cfg.Wait, func() (bool, error) {
+		err := driver.CheckEncoder(cfg)
+		return err != nil, err
+	})
+}
+
+// recoverDeviation stops the motor, optionally resynchronises XACTUAL and clears the warning
+func (driver *Driver) recoverDeviation(deviation *DeviationError, cfg EncoderSupervision) error {
+	stop := driver.Stop
+	if cfg.HardStop {
+		stop = driver.HardStop
+	}
+	if err := stop(); err != nil {
+		return err
+	}
+	if err := driver.WaitStandstill(WaitOptions{Interval: cfg.Wait.Interval, Done: cfg.Wait.Done}); err != nil {
+		return err
+	}
+	if cfg.Resync {
+		xenc, err := driver.readEncoder()
+		if err != nil {
+			return err
+		}
+		if err = driver.setPosition(xenc); err != nil {
+			return err
+		}
+		deviation.Resynced = true
+	}
+	if err := driver.clearDeviationWarning(); err != nil {
+		return err
+	}
+	return deviation
+}
@@ -0,0 +1,104 @@
+package tmc5160
+
+import (
+	"github.com/orsinium-labs/tinymath"
+)
+
+// Largest magnitude of a direct mode coil current: 248 in normal operation, up to 255 only with stealthChop
+const (
+	maxCoilCurrent        = 255
+	maxCoilCurrentNominal = 248
+)
+
+// EnterDirectMode switches to direct mode, in which the coil currents are set with SetCoilCurrents or
+// SetCurrentVector instead of by the sequencer. The currents are scaled by IHOLD, and stealthChop only regulates
+// the current at low velocities. The motor must be at standstill; it starts with the actual microstep currents
+// so that it does not jump. XDIRECT shares its address with XTARGET, so the ramp generator is put in hold mode first.
+func (driver *Driver) EnterDirectMode() error {
+	if driver.DirectMode() {
+		return nil
+	}
+	vactual, err := driver.ReadRegister(VACTUAL)
+	if err != nil {
+		return err
+	}
+	if vactual != 0 {
+		return CustomError("motor must be at standstill to enter direct mode")
+	}
+	value, err := driver.ReadRegister(MSCURACT)
+	if err != nil {
+		return err
+	}
+	mscuract := NewMSCURACT()
+	mscuract.Unpack(value)
+	if err = driver.setRampMode(HoldMode); err != nil {
+		return err
+	}
+	xdirect := NewXDIRECT()
+	xdirect.CoilA = mscuract.CUR_A
+	xdirect.CoilB = mscuract.CUR_B
+	if err = driver.WriteRegister(XDIRECT, xdirect.Pack()); err != nil {
+		return err
+	}
+	return driver.setDirectMode(true)
+}
+
+// ExitDirectMode returns control of the coil currents to the sequencer, which continues from its last
+// microstep position. The ramp generator stays in hold mode until the next MoveTo, MoveBy or Jog.
+func (driver *Driver) ExitDirectMode() error {
+	if !driver.DirectMode() {
+		return nil
+	}
+	if err := driver.setDirectMode(false); err != nil {
+		return err
+	}
+	// Replace the coil currents left in the shared register with a target the ramp generator can use
+	xactual, err := driver.ReadRegister(XACTUAL)
+	if err != nil {
+		return err
+	}
+	return driver.WriteRegister(XTARGET, xactual)
+}
+
+// DirectMode reports whether the Driver is in direct mode
+func (driver *Driver) DirectMode() bool {
+	gconf := NewGCONF()
+	gconf.Unpack(driver.shadowValue(GCONF))
+	return gconf.DirectMode
+}
+
+// setDirectMode writes GCONF.DirectMode, keeping the other GCONF settings
+func (driver *Driver) setDirectMode(enable bool) error {
+	gconf := NewGCONF()
+	gconf.Unpack(driver.shadowValue(GCONF))
+	gconf.DirectMode = enable
+	return driver.WriteRegister(GCONF, gconf.Pack())
+}
+
+// SetCoilCurrents sets the signed coil currents in direct mode. The datasheet limits them to -248..248 in
+// normal operation; values up to ±255 are accepted for stealthChop only.
+func (driver *Driver) SetCoilCurrents(coilA, coilB int16) error {
+	if !driver.DirectMode() {
+		return CustomError("not in direct mode")
+	}
+	if coilA < -maxCoilCurrent || coilA > maxCoilCurrent || coilB < -maxCoilCurrent || coilB > maxCoilCurrent {
+		return CustomError("coil current out of range (-255..255)")
+	}
+	xdirect := NewXDIRECT()
+	xdirect.CoilA = coilA
+	xdirect.CoilB = coilB
+	return driver.WriteRegister(XDIRECT, xdirect.Pack())
+}
+
+// SetCurrentVector sets the coil currents in direct mode for an electrical angle in degrees and an amplitude
+// as a fraction (0..1) of the maximum current of normal operation, ±248. Like the sequencer, coil A follows the
+// cosine and coil B the sine; one full step is 90 electrical degrees.
+func (driver *Driver) SetCurrentVector(angle float32, amplitude float32) error {
+	if amplitude < 0 || amplitude > 1 {
+		return CustomError("amplitude out of range (0..1)")
+	}
+	radians := angle * tinymath.Pi / 180
+	coilA := int16(tinymath.Round(amplitude * maxCoilCurrentNominal * tinymath.Cos(radians)))
+	coilB := int16(tinymath.Round(amplitude * maxCoilCurrentNominal * tinymath.Sin(radians)))
+	return driver.SetCoilCurrents(coilA, coilB)
+}
@@ -0,0 +1,116 @@
+package tmc5160
+
+import (
+	"github.com/orsinium-labs/tinymath"
+)
+
+// EncoderConstant is an ENC_CONST value together with the prescaler mode it is meant for
+type EncoderConstant struct {
+	Value   uint32  // ENC_CONST register value, 16.16 fixed point
+	Decimal bool    // Fractional part in 1/10000 (ENCMODE.EncSelDecimal) instead of 1/65536
+	Error   float32 // Accumulated error in microsteps per motor revolution caused by rounding
+}
+
+// CalculateEncoderConstant computes the ENC_CONST that scales encoder counts to microsteps.
+// Both the binary and the decimal prescaler are evaluated and the one with the smaller error is returned,
+// preferring binary on a tie. A negative encoderCounts reverses the counting direction.
+func CalculateEncoderConstant(encoderCounts int32, microstepsPerRev int32) (EncoderConstant, error) {
+	if encoderCounts == 0 || microstepsPerRev <= 0 {
+		return EncoderConstant{}, CustomError("encoder counts and microsteps must not be 0")
+	}
+	m, c := int64(microstepsPerRev), int64(encoderCounts)
+	if c < 0 {
+		m, c = -m, -c
+	}
+	if m/c >= 1<<15 || -m/c >= 1<<15 {
+		return EncoderConstant{}, CustomError("encoder factor out of range")
+	}
+
+	// Binary: ENC_CONST = factor * 2^16
+	binary := divRoundSigned(m<<16, c)
+	binaryErr := binary*c - m<<16 // Error per revolution in 1/2^16 microsteps
+
+	// Decimal: integer part in the upper 16 bits, fraction in 1/10000 in the lower 16 bits
+	integer := floorDiv(m, c)
+	fraction := divRoundSigned((m-integer*c)*10000, c)
+	if fraction == 10000 {
+		integer++
+		fraction = 0
+	}
+	decimalErr := (integer*10000+fraction)*c - m*10000 // Error per revolution in 1/10000 microsteps
+
+	errBinary := float32(binaryErr) / (1 << 16)
+	errDecimal := float32(decimalErr) / 10000
+	if tinymath.Abs(errDecimal) < tinymath.Abs(errBinary) {
+		return EncoderConstant{Value: uint32(integer<<16 | fraction), Decimal: true, Error: errDecimal}, nil
+	}
+	return EncoderConstant{Value: uint32(binary), Error: errBinary}, nil
+}
+
+// divRoundSigned divides with rounding half away from zero, den must be positive
+func divRoundSigned(num, den int64) int64 {
+	if num < 0 {
+		return -((-num + den/2) / den)
+	}
+	return (num + den/2) / den
+}
+
+// floorDiv divides rounding towards negative infinity, den must be positive
+func floorDiv(num, den int64) int64 {
+	q := num / den
+	if num%den < 0 {
+		q--
+	}
+	return q
+}
+
+// microstepsPerRev returns the number of microsteps per motor revolution
+func (stepper *Stepper) microstepsPerRev() int32 {
+	angle := stepper.Angle
+	if angle == 0 {
+		angle = DefaultAngle
+	}
+	return int32(tinymath.Round(360 / angle * stepper.microsteps()))
+}
+
+// SetEncoder configures the encoder interface for an incremental encoder on the motor shaft with the given
+// counts per revolution (4 times the lines of a quadrature encoder; negative if it counts against the motor).
+// ENC_CONST and the prescaler mode in ENCMODE are written, the other ENCMODE settings are kept.
+// The returned EncoderConstant reports the remaining rounding error.
+func (driver *Driver) SetEncoder(countsPerRev int32) (EncoderConstant, error) {
+	encConst, err := CalculateEncoderConstant(countsPerRev, driver.stepper.microstepsPerRev())
+	if err != nil {
+		return encConst, err
+	}
+	encmode := NewENCMODE()
+	encmode.Unpack(driver.shadowValue(ENCMODE))
+	encmode.EncSelDecimal = encConst.Decimal
+	if err = driver.WriteRegister(ENCMODE, encmode.Pack()); err != nil {
+		return encConst, err
+	}
+	return encConst, driver.WriteRegister(ENC_CONST, encConst.Value)
+}
+
+// readEncoder reads X_ENC in microsteps
+func (driver *Driver) readEncoder() (int32, error) {
+	xenc, err := driver.ReadRegister(X_ENC)
+	if err != nil {
+		return 0, err
+	}
+	return int32(xenc), nil
+}
+
+// EncoderPosition returns the encoder position in axis units.
+// X_ENC is scaled to microsteps by ENC_CONST, so it is directly comparable with Position.
+func (driver *Driver) EncoderPosition() (float32, error) {
+	microsteps, err := driver.readEncoder()
+	if err != nil {
+		return 0, err
+	}
+	return driver.axis.FromMicrosteps(microsteps), nil
+}
+
+// SetEncoderPosition redefines the encoder position in axis units, e.g. to match Position after homing
+func (driver *Driver) SetEncoderPosition(position float32) error {
+	return driver.WriteRegister(X_ENC, uint32(driver.axis.ToMicrosteps(position)))
+}
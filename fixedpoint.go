@@ -0,0 +1,109 @@
+package tmc5160
+
+// Integer variants of the conversions in conversions.go for MCUs without an FPU.
+// Velocities are in millisteps/s (1/1000 full steps per second), accelerations in full steps/s².
+// All intermediates fit in 64 bits; the clock factors are reduced by common powers of two:
+//   2^24 / (fclk[MHz] * 10^9) = 2^15 / (fclk[MHz] * 1953125)
+//   2^41 / (fclk[MHz]² * 10^12) = 2^29 / (fclk[MHz]² * 244140625)
+//   10^9 / 256 = 3906250
+
+// maxUint32 is the saturation value for conversions into physical units
+const maxUint32 = 0xFFFFFFFF
+
+// fclkMHz returns the driver clock in MHz, falling back to the internal 12MHz clock when Fclk is not set
+func (stepper *Stepper) fclkMHz() uint64 {
+	if stepper.Fclk == 0 {
+		return DEFAULT_F_CLK / 1000000
+	}
+	return uint64(stepper.Fclk)
+}
+
+// microstepsFixed returns the microstep resolution, treating an unset resolution as fullstepping
+func (stepper *Stepper) microstepsFixed() uint64 {
+	if stepper.MSteps == 0 {
+		return 1
+	}
+	return uint64(stepper.MSteps)
+}
+
+// divRound divides and rounds to the nearest integer
+func divRound(num, den uint64) uint64 {
+	return (num + den/2) / den
+}
+
+// VelocityToVMAXFixed converts a velocity in millisteps/s into a VMAX register value
+func (stepper *Stepper) VelocityToVMAXFixed(mv uint32) uint32 {
+	r := divRound(uint64(mv)*stepper.microstepsFixed()<<15, stepper.fclkMHz()*1953125)
+	return uint32(constrain(r, 0, maxVMAX))
+}
+
+// VMAXToVelocityFixed converts a VMAX (or VSTART, V1, VSTOP) register value into a velocity in millisteps/s
+func (stepper *Stepper) VMAXToVelocityFixed(vmax uint32) uint32 {
+	r := divRound(uint64(vmax)*stepper.fclkMHz()*1953125, stepper.microstepsFixed()<<15)
+	return uint32(constrain(r, 0, maxUint32))
+}
+
+// VelocityToVACTUALFixed converts a signed velocity in millisteps/s into the 24-bit two's complement VACTUAL format
+func (stepper *Stepper) VelocityToVACTUALFixed(mv int32) uint32 {
+	magnitude := uint64(mv)
+	if mv < 0 {
+		magnitude = uint64(-int64(mv))
+	}
+	r := int32(constrain(divRound(magnitude*stepper.microstepsFixed()<<15, stepper.fclkMHz()*1953125), 0, maxVACTUAL))
+	if mv < 0 {
+		r = -r
+	}
+	return uint32(r) & 0xFFFFFF
+}
+
+// VACTUALToVelocityFixed converts a VACTUAL register value into a signed velocity in millisteps/s
+func (stepper *Stepper) VACTUALToVelocityFixed(vactual uint32) int32 {
+	signed := int32(vactual<<8) >> 8 // Sign extend the 24-bit value
+	magnitude := uint64(signed)
+	if signed < 0 {
+		magnitude = uint64(-int64(signed))
+	}
+	r := int32(constrain(divRound(magnitude*stepper.fclkMHz()*1953125, stepper.microstepsFixed()<<15), 0, 0x7FFFFFFF))
+	if signed < 0 {
+		return -r
+	}
+	return r
+}
+
+// AccelerationToAMAXFixed converts an acceleration in full steps/s² into an AMAX (or A1, DMAX, D1) register value
+func (stepper *Stepper) AccelerationToAMAXFixed(a uint32) uint32 {
+	num := uint64(a) * stepper.microstepsFixed()
+	den := stepper.fclkMHz() * stepper.fclkMHz() * 244140625
+	// Saturate before shifting so the numerator stays below 2^64
+	if num > (maxAccel+1)*den>>29 {
+		return maxAccel
+	}
+	r := divRound(num<<29, den)
+	return uint32(constrain(r, 0, maxAccel))
+}
+
+// AMAXToAccelerationFixed converts an AMAX (or A1, DMAX, D1) register value into an acceleration in full steps/s²
+func (stepper *Stepper) AMAXToAccelerationFixed(amax uint32) uint32 {
+	fclk := stepper.fclkMHz()
+	r := divRound(uint64(amax)*fclk*fclk*244140625, stepper.microstepsFixed()<<29)
+	return uint32(constrain(r, 0, maxUint32))
+}
+
+// VelocityToTSTEPFixed converts a velocity in millisteps/s into TSTEP units, as used by TPWMTHRS, TCOOLTHRS and THIGH.
+// A velocity of 0 gives the largest TSTEP value.
+func (stepper *Stepper) VelocityToTSTEPFixed(mv uint32) uint32 {
+	if mv == 0 {
+		return maxTSTEP
+	}
+	r := divRound(stepper.fclkMHz()*3906250, uint64(mv))
+	return uint32(constrain(r, 0, maxTSTEP))
+}
+
+// TSTEPToVelocityFixed converts a TSTEP (or TPWMTHRS, TCOOLTHRS, THIGH) value into a velocity in millisteps/s.
+// 0 and the overflow value 2^20-1 both read as standstill.
+func (stepper *Stepper) TSTEPToVelocityFixed(tstep uint32) uint32 {
+	if tstep == 0 || tstep >= maxTSTEP {
+		return 0
+	}
+	return uint32(divRound(stepper.fclkMHz()*3906250, uint64(tstep)))
+}
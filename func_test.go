@@ -3,12 +3,17 @@
 package tmc5160
 
 import (
+	"errors"
 	"log"
+	"machine"
+	"math"
 	"testing"
+	"time"
 )
 
 func TestCurrentVelocityToVMAX(t *testing.T) {
 	stepper := NewDefaultStepper()
+	stepper.VelocitySPS = 1000
 	log.Printf("Current Velocity = %f", stepper.VelocitySPS)
 	// Expected output based on the formula
 	expectedVMAX := 1398
@@ -59,3 +64,1556 @@ func TestVMAXToTSTEP(t *testing.T) {
 		t.Errorf("VMAXToTSTEP() = %d; expected %d", result, expectedTSTEP)
 	}
 }
+
+func TestPlanRamp(t *testing.T) {
+	stepper := NewDefaultStepper()
+
+	// 1000 full steps/s at 1000 full steps/s² with only VMAX and AMAX given
+	profile := RampProfile{AMax: 1000, VMax: 1000}
+	expected := RampRegisters{AMax: 244, VMax: 22370, DMax: 244, D1: 244, VStop: minVSTOP}
+
+	result, err := stepper.PlanRamp(profile)
+	if err != nil {
+		t.Fatalf("PlanRamp() error: %v", err)
+	}
+	if result != expected {
+		t.Errorf("PlanRamp() = %+v; expected %+v", result, expected)
+	}
+
+	// VSTOP must end up above VSTART
+	profile.VStart = 100
+	profile.VStop = 50
+	result, err = stepper.PlanRamp(profile)
+	if err != nil {
+		t.Fatalf("PlanRamp() error: %v", err)
+	}
+	if result.VStop <= result.VStart {
+		t.Errorf("PlanRamp() VSTOP = %d; expected above VSTART %d", result.VStop, result.VStart)
+	}
+
+	if _, err = stepper.PlanRamp(RampProfile{VMax: 1000}); err == nil {
+		t.Errorf("PlanRamp() without AMax should fail")
+	}
+}
+
+func TestVelocityConversions(t *testing.T) {
+	stepper := NewDefaultStepper()
+	tests := []struct {
+		velocity float32 // full steps/s
+		vmax     uint32
+		tstep    uint32
+	}{
+		{1, 22, 46875},
+		{200, 4474, 234},
+		{1000, 22370, 47},
+		{5000, 111848, 9},
+	}
+	for _, tt := range tests {
+		if got := stepper.VelocityToVMAX(tt.velocity); got != tt.vmax {
+			t.Errorf("VelocityToVMAX(%v) = %d; expected %d", tt.velocity, got, tt.vmax)
+		}
+		if got := stepper.VelocityToVMAX(stepper.VMAXToVelocity(tt.vmax)); got != tt.vmax {
+			t.Errorf("VMAX round trip of %d = %d", tt.vmax, got)
+		}
+		if got := stepper.VelocityToTSTEP(tt.velocity); got != tt.tstep {
+			t.Errorf("VelocityToTSTEP(%v) = %d; expected %d", tt.velocity, got, tt.tstep)
+		}
+		if got := stepper.VelocityToTSTEP(stepper.TSTEPToVelocity(tt.tstep)); got != tt.tstep {
+			t.Errorf("TSTEP round trip of %d = %d", tt.tstep, got)
+		}
+		for _, v := range []float32{tt.velocity, -tt.velocity} {
+			if got := stepper.VACTUALToVelocity(stepper.VelocityToVACTUAL(v)); !approxEqual(got, v, 0.05) {
+				t.Errorf("VACTUAL round trip of %v = %v", v, got)
+			}
+		}
+	}
+	if got := stepper.VelocityToTSTEP(0); got != maxTSTEP {
+		t.Errorf("VelocityToTSTEP(0) = %d; expected %d", got, maxTSTEP)
+	}
+	if got := stepper.VMAXToTSTEP(0); got != maxTSTEP {
+		t.Errorf("VMAXToTSTEP(0) = %d; expected %d", got, maxTSTEP)
+	}
+}
+
+func TestAccelerationConversions(t *testing.T) {
+	stepper := NewDefaultStepper()
+	tests := []struct {
+		acceleration float32 // full steps/s²
+		amax         uint32
+	}{
+		{100, 24},
+		{1000, 244},
+		{10000, 2443},
+		{100000, 24434},
+	}
+	for _, tt := range tests {
+		if got := stepper.AccelerationToAMAX(tt.acceleration); got != tt.amax {
+			t.Errorf("AccelerationToAMAX(%v) = %d; expected %d", tt.acceleration, got, tt.amax)
+		}
+		if got := stepper.AccelerationToAMAX(stepper.AMAXToAcceleration(tt.amax)); got != tt.amax {
+			t.Errorf("AMAX round trip of %d = %d", tt.amax, got)
+		}
+	}
+}
+
+func TestPositionConversions(t *testing.T) {
+	stepper := NewDefaultStepper()
+	tests := []struct {
+		fullsteps  float32
+		microsteps int32
+		xactual    uint32
+	}{
+		{0, 0, 0},
+		{200, 3200, 3200},
+		{-1, -16, 0xFFFFFFF0},
+		{0.5, 8, 8},
+	}
+	for _, tt := range tests {
+		if got := stepper.FullstepsToXACTUAL(tt.fullsteps); got != tt.xactual {
+			t.Errorf("FullstepsToXACTUAL(%v) = %d; expected %d", tt.fullsteps, got, tt.xactual)
+		}
+		if got := stepper.XACTUALToFullsteps(tt.xactual); got != tt.fullsteps {
+			t.Errorf("XACTUALToFullsteps(%d) = %v; expected %v", tt.xactual, got, tt.fullsteps)
+		}
+		if got := MicrostepsToXACTUAL(tt.microsteps); got != tt.xactual {
+			t.Errorf("MicrostepsToXACTUAL(%d) = %d; expected %d", tt.microsteps, got, tt.xactual)
+		}
+		if got := XACTUALToMicrosteps(tt.xactual); got != tt.microsteps {
+			t.Errorf("XACTUALToMicrosteps(%d) = %d; expected %d", tt.xactual, got, tt.microsteps)
+		}
+	}
+}
+
+func approxEqual(a, b, tolerance float32) bool {
+	d := a - b
+	if d < 0 {
+		d = -d
+	}
+	return d <= tolerance
+}
+
+func TestFixedPointConversions(t *testing.T) {
+	for _, mSteps := range []uint16{Step_1, Step_16, Step_128, Step_256} {
+		stepper := NewDefaultStepper()
+		stepper.MSteps = mSteps
+		for _, v := range []uint32{1, 733, 10000, 250000, 1000000, 37500000} {
+			velocity := float32(v) / 1000
+			if got, want := stepper.VelocityToVMAXFixed(v), stepper.VelocityToVMAX(velocity); !withinOneLSB(got, want) {
+				t.Errorf("MSteps %d: VelocityToVMAXFixed(%d) = %d; float gives %d", mSteps, v, got, want)
+			}
+			if got, want := stepper.VelocityToTSTEPFixed(v), stepper.VelocityToTSTEP(velocity); !withinOneLSB(got, want) {
+				t.Errorf("MSteps %d: VelocityToTSTEPFixed(%d) = %d; float gives %d", mSteps, v, got, want)
+			}
+			if got, want := stepper.VelocityToVACTUALFixed(-int32(v)), stepper.VelocityToVACTUAL(-velocity); !withinOneLSB(got, want) {
+				t.Errorf("MSteps %d: VelocityToVACTUALFixed(%d) = %d; float gives %d", mSteps, -int32(v), got, want)
+			}
+		}
+		for _, a := range []uint32{1, 100, 1000, 50000, 1000000} {
+			if got, want := stepper.AccelerationToAMAXFixed(a), stepper.AccelerationToAMAX(float32(a)); !withinOneLSB(got, want) {
+				t.Errorf("MSteps %d: AccelerationToAMAXFixed(%d) = %d; float gives %d", mSteps, a, got, want)
+			}
+		}
+		for _, vmax := range []uint32{1, 5145, 71583, 2000000} {
+			if got, want := stepper.VMAXToVelocityFixed(vmax), stepper.VMAXToVelocity(vmax)*1000; !approxEqual(float32(got), want, want/100000+1) {
+				t.Errorf("MSteps %d: VMAXToVelocityFixed(%d) = %d; float gives %v", mSteps, vmax, got, want)
+			}
+			vactual := -vmax & 0xFFFFFF
+			if got, want := stepper.VACTUALToVelocityFixed(vactual), stepper.VACTUALToVelocity(vactual)*1000; !approxEqual(float32(got), want, -want/100000+1) {
+				t.Errorf("MSteps %d: VACTUALToVelocityFixed(%d) = %d; float gives %v", mSteps, vactual, got, want)
+			}
+		}
+		for _, amax := range []uint32{1, 244, 65535} {
+			if got, want := stepper.AMAXToAccelerationFixed(amax), stepper.AMAXToAcceleration(amax); !approxEqual(float32(got), want, want/100000+1) {
+				t.Errorf("MSteps %d: AMAXToAccelerationFixed(%d) = %d; float gives %v", mSteps, amax, got, want)
+			}
+		}
+		for _, tstep := range []uint32{1, 47, 46875, maxTSTEP - 1} {
+			if got, want := stepper.TSTEPToVelocityFixed(tstep), stepper.TSTEPToVelocity(tstep)*1000; !approxEqual(float32(got), want, want/100000+1) {
+				t.Errorf("MSteps %d: TSTEPToVelocityFixed(%d) = %d; float gives %v", mSteps, tstep, got, want)
+			}
+		}
+	}
+}
+
+func withinOneLSB(a, b uint32) bool {
+	return a-b <= 1 || b-a <= 1
+}
+
+func TestAxisConversions(t *testing.T) {
+	stepper := NewDefaultStepper()
+	geared := NewDefaultStepper()
+	geared.GearRatio = 5
+	tests := []struct {
+		name         string
+		axis         Axis
+		stepsPerUnit float32
+		position     float32
+		microsteps   int32
+	}{
+		{"lead screw 8mm", NewLeadScrewAxis(&stepper, 8), 25, 10, 4000},
+		{"belt 20T GT2", NewBeltAxis(&stepper, 20, 2), 5, -12.5, -1000},
+		{"rotary 5:1", NewRotaryAxis(&geared), 200 * 5 / 360.0, 90, 4000},
+		{"revolutions", NewRevolutionAxis(&stepper), 200, 1.5, 4800},
+		{"full steps", Axis{Stepper: &stepper}, 1, 100, 1600},
+	}
+	for _, tt := range tests {
+		if got := tt.axis.StepsPerUnit(); !approxEqual(got, tt.stepsPerUnit, 0.0001) {
+			t.Errorf("%s: StepsPerUnit() = %v; expected %v", tt.name, got, tt.stepsPerUnit)
+		}
+		if got := tt.axis.ToMicrosteps(tt.position); got != tt.microsteps {
+			t.Errorf("%s: ToMicrosteps(%v) = %d; expected %d", tt.name, tt.position, got, tt.microsteps)
+		}
+		if got := tt.axis.XACTUALToPosition(tt.axis.PositionToXACTUAL(tt.position)); !approxEqual(got, tt.position, 0.001) {
+			t.Errorf("%s: position round trip of %v = %v", tt.name, tt.position, got)
+		}
+		if got, want := tt.axis.VelocityToVMAX(10), stepper.VelocityToVMAX(10*tt.stepsPerUnit); got != want {
+			t.Errorf("%s: VelocityToVMAX(10) = %d; expected %d", tt.name, got, want)
+		}
+	}
+}
+
+// fakeComm is an in-memory RegisterComm that records every write.
+// In positioning mode a move to XTARGET completes instantly.
+type fakeComm struct {
+	registers map[uint8]uint32
+	writes    []fakeWrite
+	onWrite   func(register uint8, value uint32) // Optional hook to simulate the chip reacting to a write
+	onRead    func(register uint8)               // Optional hook to simulate the chip changing between reads
+}
+
+type fakeWrite struct {
+	register uint8
+	value    uint32
+}
+
+func newFakeComm() *fakeComm {
+	return &fakeComm{registers: map[uint8]uint32{}}
+}
+
+func (f *fakeComm) ReadRegister(register uint8, driverIndex uint8) (uint32, error) {
+	if f.onRead != nil {
+		f.onRead(register)
+	}
+	value := f.registers[register]
+	if register == RAMP_STAT && f.registers[RAMPMODE] == uint32(PositioningMode) && f.registers[XACTUAL] == f.registers[XTARGET] {
+		value |= 1 << 9 // position_reached
+	}
+	return value, nil
+}
+
+func (f *fakeComm) WriteRegister(register uint8, value uint32, driverIndex uint8) error {
+	f.writes = append(f.writes, fakeWrite{register, value})
+	switch {
+	case register == RAMP_STAT || register == ENC_STATUS || register == GSTAT:
+		f.registers[register] &^= value // Event flags are cleared by writing 1
+	case register == XTARGET && f.registers[RAMPMODE] == uint32(PositioningMode):
+		f.registers[XTARGET] = value
+		f.registers[XACTUAL] = value
+	default:
+		f.registers[register] = value
+	}
+	if f.onWrite != nil {
+		f.onWrite(register, value)
+	}
+	return nil
+}
+
+// written returns the values written to a register in order
+func (f *fakeComm) written(register uint8) []uint32 {
+	var values []uint32
+	for _, w := range f.writes {
+		if w.register == register {
+			values = append(values, w.value)
+		}
+	}
+	return values
+}
+
+func TestDriverWithoutConstructor(t *testing.T) {
+	// A Driver or Axis not created with the constructors must return errors or defaults, not panic
+	var axis Axis
+	if got := axis.ToMicrosteps(3); got != 3 {
+		t.Errorf("Axis{}.ToMicrosteps(3) = %d; expected 3 full steps at 1 microstep", got)
+	}
+	var unset Driver
+	if _, err := unset.Position(); err == nil {
+		t.Errorf("Position() without a communication interface succeeded; expected an error")
+	}
+	driver := &Driver{comm: newFakeComm()}
+	for _, call := range []struct {
+		name string
+		fn   func() error
+	}{
+		{"SetSpeed", func() error { return driver.SetSpeed(10) }},
+		{"SetAcceleration", func() error { return driver.SetAcceleration(100) }},
+		{"MoveTo", func() error { return driver.MoveTo(5) }},
+		{"Stop", func() error { return driver.Stop() }},
+		{"Jog", func() error { return driver.Jog(3) }},
+		{"SetMicrosteps", func() error { return driver.SetMicrosteps(16) }},
+	} {
+		if err := call.fn(); err != nil {
+			t.Errorf("%s() on a zero Driver error: %v", call.name, err)
+		}
+	}
+}
+
+func TestMotion(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	driver.SetAxis(NewLeadScrewAxis(nil, 8))
+	if err := driver.SetRamp(RampProfile{AMax: 1000, VMax: 1000}); err != nil {
+		t.Fatalf("SetRamp() error: %v", err)
+	}
+
+	if err := driver.MoveTo(10); err != nil {
+		t.Fatalf("MoveTo() error: %v", err)
+	}
+	if got := comm.registers[XTARGET]; got != 4000 {
+		t.Errorf("MoveTo(10) XTARGET = %d; expected 4000", got)
+	}
+	if err := driver.MoveBy(-20); err != nil {
+		t.Fatalf("MoveBy() error: %v", err)
+	}
+	if got := XACTUALToMicrosteps(comm.registers[XTARGET]); got != -4000 {
+		t.Errorf("MoveBy(-20) XTARGET = %d; expected -4000", got)
+	}
+
+	// Stop while moving backwards keeps the direction and ramps down to 0
+	comm.registers[VACTUAL] = 0xFFFF00
+	comm.registers[XACTUAL] = MicrostepsToXACTUAL(-1234)
+	if err := driver.Stop(); err != nil {
+		t.Fatalf("Stop() error: %v", err)
+	}
+	if comm.registers[RAMPMODE] != uint32(VelocityNegativeMode) || comm.registers[VMAX] != 0 {
+		t.Errorf("Stop() RAMPMODE = %d, VMAX = %d; expected %d, 0", comm.registers[RAMPMODE], comm.registers[VMAX], VelocityNegativeMode)
+	}
+
+	// Returning to positioning starts from the actual position
+	if err := driver.MoveBy(1); err != nil {
+		t.Fatalf("MoveBy() error: %v", err)
+	}
+	if got := XACTUALToMicrosteps(comm.registers[XTARGET]); got != -1234+400 {
+		t.Errorf("MoveBy(1) after Stop XTARGET = %d; expected %d", got, -1234+400)
+	}
+	if comm.registers[RAMPMODE] != uint32(PositioningMode) || comm.registers[VMAX] != 22370 {
+		t.Errorf("MoveBy() after Stop RAMPMODE = %d, VMAX = %d; expected %d, 22370", comm.registers[RAMPMODE], comm.registers[VMAX], PositioningMode)
+	}
+
+	// A hard stop uses the maximum AMAX until the next move
+	if err := driver.HardStop(); err != nil {
+		t.Fatalf("HardStop() error: %v", err)
+	}
+	if got := comm.registers[AMAX]; got != maxAccel {
+		t.Errorf("HardStop() AMAX = %d; expected %d", got, maxAccel)
+	}
+	if err := driver.MoveTo(0); err != nil {
+		t.Fatalf("MoveTo() error: %v", err)
+	}
+	if got := comm.registers[AMAX]; got != 244 {
+		t.Errorf("MoveTo() after HardStop AMAX = %d; expected 244", got)
+	}
+
+	// Stop decelerates with a separately configured DMax
+	ramp := driver.Ramp()
+	ramp.DMax = ramp.AMax * 2
+	driver.SetRamp(ramp)
+	if err := driver.Stop(); err != nil {
+		t.Fatalf("Stop() error: %v", err)
+	}
+	if got, want := comm.registers[AMAX], driver.stepper.AccelerationToAMAX(ramp.DMax); got != want {
+		t.Errorf("Stop() with DMax AMAX = %d; expected %d", got, want)
+	}
+	driver.MoveTo(0)
+	if got := comm.registers[AMAX]; got != 244 {
+		t.Errorf("MoveTo() after Stop AMAX = %d; expected 244", got)
+	}
+}
+
+func TestWait(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	opts := WaitOptions{Interval: time.Millisecond, Timeout: 20 * time.Millisecond}
+
+	comm.registers[RAMP_STAT] = 1 << 9 // position_reached
+	if err := driver.WaitPositionReached(opts); err != nil {
+		t.Errorf("WaitPositionReached() error: %v", err)
+	}
+	if err := driver.WaitStandstill(opts); err != ErrWaitTimeout {
+		t.Errorf("WaitStandstill() = %v; expected %v", err, ErrWaitTimeout)
+	}
+
+	comm.registers[RAMP_STAT] = 1<<6 | 1<<10 // event_stop_sg, vzero
+	var motionErr *MotionError
+	if err := driver.WaitStandstill(opts); !errors.Is(err, ErrStallGuardStop) || !errors.As(err, &motionErr) {
+		t.Errorf("WaitStandstill() = %v; expected %v", err, ErrStallGuardStop)
+	}
+
+	comm.registers[RAMP_STAT] = 0
+	comm.registers[GSTAT] = 1 << 1 // drv_err
+	if err := driver.WaitVelocityReached(opts); !errors.Is(err, ErrDriverFault) {
+		t.Errorf("WaitVelocityReached() = %v; expected %v", err, ErrDriverFault)
+	}
+
+	comm.registers[GSTAT] = 0
+	done := make(chan struct{})
+	close(done)
+	if err := driver.WaitVelocityReached(WaitOptions{Interval: time.Millisecond, Done: done}); err != ErrWaitCanceled {
+		t.Errorf("WaitVelocityReached() = %v; expected %v", err, ErrWaitCanceled)
+	}
+}
+
+func TestJog(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	driver.SetAxis(NewRevolutionAxis(nil))
+	if err := driver.Jog(1); err == nil {
+		t.Errorf("Jog() without acceleration should fail")
+	}
+	if err := driver.SetAcceleration(5); err != nil {
+		t.Fatalf("SetAcceleration() error: %v", err)
+	}
+
+	// -5 rev/s = -1000 full steps/s
+	if err := driver.Jog(-5); err != nil {
+		t.Fatalf("Jog() error: %v", err)
+	}
+	if comm.registers[RAMPMODE] != uint32(VelocityNegativeMode) || comm.registers[VMAX] != 22370 || comm.registers[AMAX] != 244 {
+		t.Errorf("Jog(-5) RAMPMODE = %d, VMAX = %d, AMAX = %d; expected %d, 22370, 244",
+			comm.registers[RAMPMODE], comm.registers[VMAX], comm.registers[AMAX], VelocityNegativeMode)
+	}
+	if err := driver.JogFixed(1000000); err != nil {
+		t.Fatalf("JogFixed() error: %v", err)
+	}
+	if comm.registers[RAMPMODE] != uint32(VelocityPositiveMode) || comm.registers[VMAX] != 22370 {
+		t.Errorf("JogFixed(1000000) RAMPMODE = %d, VMAX = %d; expected %d, 22370", comm.registers[RAMPMODE], comm.registers[VMAX], VelocityPositiveMode)
+	}
+
+	comm.registers[RAMP_STAT] = 1 << 10 // vzero
+	comm.registers[XACTUAL] = 6400
+	driver.ramp.DMax = 2 * driver.ramp.AMax
+	position, err := driver.JogStop(WaitOptions{Interval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("JogStop() error: %v", err)
+	}
+	if amax := driver.stepper.AccelerationToAMAX(driver.ramp.DMax); position != 2 || comm.registers[VMAX] != 0 || comm.registers[AMAX] != amax {
+		t.Errorf("JogStop() = %v, VMAX = %d, AMAX = %d; expected 2, 0, %d like Stop", position, comm.registers[VMAX], comm.registers[AMAX], amax)
+	}
+}
+
+func TestHomeSensorless(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	driver.SetAxis(NewRevolutionAxis(nil))
+	gconf := NewGCONF()
+	gconf.EnPwmMode = true
+	driver.WriteRegister(GCONF, gconf.Pack())
+	driver.WriteRegister(COOLCONF, 0x1234)
+	if err := driver.SetRamp(RampProfile{AMax: 1000, VMax: 1000}); err != nil {
+		t.Fatalf("SetRamp() error: %v", err)
+	}
+	comm.registers[XACTUAL] = 5000
+	comm.onWrite = func(register uint8, value uint32) {
+		if register == RAMPMODE && value == uint32(VelocityNegativeMode) {
+			comm.registers[RAMP_STAT] |= 1<<6 | 1<<10 // Stall: event_stop_sg, vzero
+		}
+	}
+
+	if err := driver.HomeSensorless(HomeNegative, 1, -10); err != nil {
+		t.Fatalf("HomeSensorless() error: %v", err)
+	}
+	if got := comm.written(COOLCONF); len(got) != 3 || got[1]>>16&0x7F != 0x76 {
+		t.Errorf("HomeSensorless() COOLCONF writes = %x; expected SGT -10 during homing", got)
+	}
+	if got := comm.written(GCONF); got[1]&GCONF_EnPwmMode_Mask != 0 {
+		t.Errorf("HomeSensorless() did not switch to spreadCycle")
+	}
+	if got := comm.written(XTARGET); len(got) < 2 || got[1] != 5000+DefaultHomingBackoff*16 {
+		t.Errorf("HomeSensorless() XTARGET writes = %d; expected back-off to %d", got, 5000+DefaultHomingBackoff*16)
+	}
+	if comm.registers[XACTUAL] != 0 || comm.registers[XTARGET] != 0 {
+		t.Errorf("HomeSensorless() XACTUAL = %d, XTARGET = %d; expected 0", comm.registers[XACTUAL], comm.registers[XTARGET])
+	}
+	if comm.registers[GCONF] != gconf.Pack() || comm.registers[COOLCONF] != 0x1234 || comm.registers[SW_MODE] != 0 || comm.registers[VMAX] != 22370 {
+		t.Errorf("HomeSensorless() did not restore GCONF, COOLCONF, SW_MODE and VMAX")
+	}
+
+	// A failed homing move still restores the configuration
+	comm.onWrite = nil
+	err := driver.HomeSensorlessWithOptions(HomePositive, 1, 5, HomingOptions{Wait: WaitOptions{Interval: time.Millisecond, Timeout: 5 * time.Millisecond}})
+	if err != ErrWaitTimeout {
+		t.Errorf("HomeSensorlessWithOptions() = %v; expected %v", err, ErrWaitTimeout)
+	}
+	if comm.registers[GCONF] != gconf.Pack() || comm.registers[COOLCONF] != 0x1234 || comm.registers[SW_MODE] != 0 || comm.registers[VMAX] != 0 {
+		t.Errorf("HomeSensorlessWithOptions() did not restore the configuration and stop after a timeout")
+	}
+}
+
+func TestHomeToSwitch(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	driver.SetAxis(NewRevolutionAxis(nil))
+	if err := driver.SetRamp(RampProfile{AMax: 1000, VMax: 1000}); err != nil {
+		t.Fatalf("SetRamp() error: %v", err)
+	}
+	comm.registers[RAMP_STAT] = 1 << 0 // Homing starts on the active left switch
+	latches := []uint32{MicrostepsToXACTUAL(-1000), MicrostepsToXACTUAL(-1010)}
+	stops := []uint32{MicrostepsToXACTUAL(-1100), MicrostepsToXACTUAL(-1050)}
+	approaches := 0
+	// The soft stop after the switch event decelerates for a few more polls of RAMP_STAT
+	coasting, movedWhileCoasting := 0, false
+	comm.onRead = func(register uint8) {
+		switch {
+		case register == XACTUAL && coasting > 0:
+			movedWhileCoasting = true
+		case register == RAMP_STAT && coasting > 0:
+			comm.registers[XACTUAL] -= 10
+			if coasting--; coasting == 0 {
+				comm.registers[RAMP_STAT] |= 1 << 10 // vzero
+			}
+		}
+	}
+	comm.onWrite = func(register uint8, value uint32) {
+		if (register == XACTUAL || register == XTARGET) && coasting > 0 {
+			movedWhileCoasting = true
+		}
+		switch {
+		case register == RAMPMODE && value == uint32(VelocityPositiveMode):
+			comm.registers[RAMP_STAT] &^= 1 << 0 // Left the switch
+		case register == VMAX && value == 0 && comm.registers[RAMP_STAT]&(1<<4) != 0:
+			coasting = 3
+		case register == VMAX && value == 0:
+			comm.registers[RAMP_STAT] |= 1 << 10 // vzero
+		case register == VMAX:
+			comm.registers[RAMP_STAT] &^= 1 << 10
+		case register == RAMPMODE && value == uint32(VelocityNegativeMode) && approaches < len(latches):
+			comm.registers[XLATCH] = latches[approaches]
+			comm.registers[XACTUAL] = stops[approaches]
+			comm.registers[RAMP_STAT] |= 1<<0 | 1<<4 // status_stop_l, event_stop_l
+			approaches++
+		case register == XTARGET && comm.registers[RAMPMODE] == uint32(PositioningMode):
+			comm.registers[RAMP_STAT] &^= 1<<0 | 1<<4
+		}
+	}
+
+	err := driver.HomeToSwitch(SwitchHoming{Switch: LeftSwitch, ActiveLow: true, SoftStop: true, Speed: 2, SlowSpeed: 0.5})
+	if err != nil {
+		t.Fatalf("HomeToSwitch() error: %v", err)
+	}
+	if approaches != 2 {
+		t.Errorf("HomeToSwitch() approaches = %d; expected 2", approaches)
+	}
+	if movedWhileCoasting {
+		t.Errorf("HomeToSwitch() used XACTUAL or XTARGET before the motor came to rest after the stop event")
+	}
+	swMode := NewSW_MODE()
+	swMode.Unpack(comm.written(SW_MODE)[0])
+	if !swMode.StopLEnable || !swMode.PolStopL || !swMode.LatchLActive || swMode.StopREnable {
+		t.Errorf("HomeToSwitch() SW_MODE = %x; expected left stop, active low, latch on active edge", comm.written(SW_MODE)[0])
+	}
+	// The latched edge is at 0: -1050 - 30 coasted - -1010 = -70, then backed off by DefaultHomingBackoff full steps
+	if got := XACTUALToMicrosteps(comm.registers[XACTUAL]); got != -70+DefaultHomingBackoff*16 {
+		t.Errorf("HomeToSwitch() XACTUAL = %d; expected %d", got, -70+DefaultHomingBackoff*16)
+	}
+	if comm.registers[SW_MODE] != 0 || comm.registers[VMAX] != 22370 {
+		t.Errorf("HomeToSwitch() did not restore SW_MODE and VMAX")
+	}
+
+	if err = driver.HomeToSwitch(SwitchHoming{Switch: RightSwitch}); err == nil {
+		t.Errorf("HomeToSwitch() without speed succeeded; expected an error")
+	}
+}
+
+func TestTuneStallGuard(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	driver.SetAxis(NewRevolutionAxis(nil))
+	driver.WriteRegister(COOLCONF, 0x0005) // coolStep enabled with SEMIN 5
+	if err := driver.SetAcceleration(10); err != nil {
+		t.Fatalf("SetAcceleration() error: %v", err)
+	}
+	restoredMoving := false
+	comm.onWrite = func(register uint8, value uint32) {
+		switch register {
+		case RAMPMODE:
+			comm.registers[RAMP_STAT] |= 1 << 8 // velocity_reached
+		case VMAX:
+			if value == 0 {
+				comm.registers[RAMP_STAT] = 1 << 10 // vzero
+			} else {
+				comm.registers[RAMP_STAT] = 1 << 8
+			}
+		case COOLCONF:
+			if value == 0x0005 && comm.registers[RAMP_STAT]&(1<<10) == 0 {
+				restoredMoving = true
+			}
+			// SG_RESULT rises by 20 per SGT step and reaches 0 at SGT -5
+			sgt := int8(value>>16&0x7F<<1) >> 1
+			comm.registers[DRV_STATUS] = uint32(max(0, (int(sgt)+5)*20))
+		}
+	}
+
+	cfg := StallGuardTuning{Speed: -2, SgtRange: true, MinSgt: -10, MaxSgt: 10, Margin: 30, Samples: 3, Settle: time.Microsecond, Interval: time.Microsecond}
+	result, err := driver.TuneStallGuard(cfg)
+	if err != nil {
+		t.Fatalf("TuneStallGuard() error: %v", err)
+	}
+	if result.Sgt != -3 || len(result.Samples) != 21 {
+		t.Errorf("TuneStallGuard() = SGT %d after %d settings; expected SGT -3 after all 21", result.Sgt, len(result.Samples))
+	}
+	for i, sample := range result.Samples {
+		expected := uint16(max(0, (int(sample.Sgt)+5)*20))
+		if int(sample.Sgt) != i-10 || sample.Min != expected || sample.Max != expected || sample.Mean != float32(expected) {
+			t.Errorf("TuneStallGuard() statistics %d = %+v; expected SGT %d at %d", i, sample, i-10, expected)
+		}
+	}
+	if comm.registers[COOLCONF] != 0x0005 || comm.registers[VMAX] != 0 {
+		t.Errorf("TuneStallGuard() did not restore COOLCONF and stop the motor")
+	}
+	if got := comm.written(COOLCONF)[1]; got&0xF != 0 {
+		t.Errorf("TuneStallGuard() left coolStep enabled while tuning")
+	}
+	if restoredMoving {
+		t.Errorf("TuneStallGuard() restored COOLCONF before standstill")
+	}
+
+	cfg.MaxSgt = -6
+	if _, err = driver.TuneStallGuard(cfg); err != ErrNoStallGuardThreshold {
+		t.Errorf("TuneStallGuard() = %v; expected %v", err, ErrNoStallGuardThreshold)
+	}
+
+	// SGT 0 alone, and the full range without SgtRange
+	cfg.MinSgt, cfg.MaxSgt = 0, 0
+	if result, err = driver.TuneStallGuard(cfg); err != nil || len(result.Samples) != 1 || result.Sgt != 0 {
+		t.Errorf("TuneStallGuard() of SGT 0 = %+v, %v", result, err)
+	}
+	cfg.SgtRange = false
+	if result, err = driver.TuneStallGuard(cfg); err != nil || len(result.Samples) != 128 || result.Sgt != -3 {
+		t.Errorf("TuneStallGuard() of -64..63 = SGT %d after %d settings, %v", result.Sgt, len(result.Samples), err)
+	}
+}
+
+func TestTuneStealthChop(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	iholdrun := NewIHOLD_IRUN()
+	iholdrun.Ihold = 8
+	iholdrun.Irun = 20
+	driver.WriteRegister(IHOLD_IRUN, iholdrun.Pack())
+	driver.WriteRegister(PWMCONF, 0xC40C001E)
+	if err := driver.SetAcceleration(1000); err != nil {
+		t.Fatalf("SetAcceleration() error: %v", err)
+	}
+	comm.registers[RAMP_STAT] = 1 << 10 // vzero
+	comm.registers[PWM_SCALE] = 0x1FE << 16
+	comm.registers[PWM_AUTO] = 40<<16 | 30
+	comm.onWrite = func(register uint8, value uint32) {
+		switch {
+		case register == RAMPMODE:
+			comm.registers[RAMP_STAT] = 1 << 8 // velocity_reached
+		case register == VMAX && value == 0:
+			comm.registers[RAMP_STAT] = 1 << 10 // vzero
+		}
+	}
+
+	tuning, err := driver.TuneStealthChop(StealthChopTuning{Standstill: time.Microsecond, Wait: WaitOptions{Interval: time.Microsecond}})
+	if err != nil {
+		t.Fatalf("TuneStealthChop() error: %v", err)
+	}
+	if tuning.PwmOfs != 30 || tuning.PwmGrad != 40 {
+		t.Errorf("TuneStealthChop() = %+v; expected PwmOfs 30, PwmGrad 40", tuning)
+	}
+	if got := comm.written(IHOLD_IRUN)[1]; got&0x1F != 20 {
+		t.Errorf("TuneStealthChop() IHOLD during AT#1 = %d; expected IRUN 20", got&0x1F)
+	}
+	if got := comm.written(VMAX); len(got) < 1 || driver.stepper.VMAXToVelocity(got[0]) < 399 || driver.stepper.VMAXToVelocity(got[0]) > 401 {
+		t.Errorf("TuneStealthChop() AT#2 VMAX = %v; expected 400 full steps/s", got)
+	}
+	if comm.registers[IHOLD_IRUN] != iholdrun.Pack() || comm.registers[PWMCONF] != 0xC40C281E || comm.registers[VMAX] != 0 {
+		t.Errorf("TuneStealthChop() PWMCONF = %x; expected the configuration restored with the learned values", comm.registers[PWMCONF])
+	}
+
+	var params MotorParameters
+	tuning.Apply(&params)
+	if params.pwmOfsInitial != 30 || params.pwmGradInitial != 40 {
+		t.Errorf("PwmTuning.Apply() = %d, %d; expected 30, 40", params.pwmOfsInitial, params.pwmGradInitial)
+	}
+}
+
+func TestSetModeThresholds(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	chopconf := NewCHOPCONF()
+	chopconf.Toff = 5
+	driver.WriteRegister(CHOPCONF, chopconf.Pack())
+
+	thresholds := ModeThresholds{StealthChop: 100, CoolStep: 150, High: 1000, Fullstep: true}
+	if err := driver.SetModeThresholds(thresholds); err != nil {
+		t.Fatalf("SetModeThresholds() error: %v", err)
+	}
+	stepper := NewDefaultStepper()
+	if comm.registers[TPWMTHRS] != stepper.VelocityToTSTEP(100) || comm.registers[TCOOLTHRS] != stepper.VelocityToTSTEP(150) || comm.registers[THIGH] != stepper.VelocityToTSTEP(1000) {
+		t.Errorf("SetModeThresholds() TPWMTHRS, TCOOLTHRS, THIGH = %d, %d, %d", comm.registers[TPWMTHRS], comm.registers[TCOOLTHRS], comm.registers[THIGH])
+	}
+	chopconf.Unpack(comm.registers[CHOPCONF])
+	if !chopconf.Vhighfs || chopconf.Vhighchm || chopconf.Toff != 5 {
+		t.Errorf("SetModeThresholds() CHOPCONF = %x; expected vhighfs with TOFF kept", comm.registers[CHOPCONF])
+	}
+	got := driver.ModeThresholds()
+	if !approxEqual(got.StealthChop, 100, 0.5) || !approxEqual(got.CoolStep, 150, 1) || !approxEqual(got.High, 1000, 5) || !got.Fullstep {
+		t.Errorf("ModeThresholds() = %+v; expected %+v", got, thresholds)
+	}
+
+	if err := driver.SetModeThresholds(ModeThresholds{}); err != nil || comm.registers[TPWMTHRS] != 0 || comm.registers[THIGH] != 0 {
+		t.Errorf("SetModeThresholds() with all thresholds off = %v; expected 0 registers", err)
+	}
+	for _, bad := range []ModeThresholds{
+		{StealthChop: 200, CoolStep: 100},
+		{CoolStep: 1000, High: 1000},
+		{StealthChop: 2000, High: 1000},
+		{ConstantOff: true},
+		{CoolStep: -1},
+	} {
+		if err := driver.SetModeThresholds(bad); err == nil {
+			t.Errorf("SetModeThresholds(%+v) succeeded; expected an error", bad)
+		}
+	}
+}
+
+func TestCoolStep(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	iholdrun := NewIHOLD_IRUN()
+	iholdrun.Irun = 15
+	driver.WriteRegister(IHOLD_IRUN, iholdrun.Pack())
+	coolconf := NewCOOLCONF()
+	coolconf.Sgt = 0x76
+	driver.WriteRegister(COOLCONF, coolconf.Pack())
+
+	err := driver.SetCoolStep(CoolStep{MinCurrent: 0.25, IncreaseBelow: 96, DecreaseAbove: 320, Response: CoolStepFast, MinSpeed: 100})
+	if err != nil {
+		t.Fatalf("SetCoolStep() error: %v", err)
+	}
+	coolconf.Unpack(comm.registers[COOLCONF])
+	if coolconf.Semin != 3 || coolconf.Semax != 6 || coolconf.Seup != 2 || coolconf.Sedn != 2 || !coolconf.Seimin || coolconf.Sgt != 0x76 {
+		t.Errorf("SetCoolStep() COOLCONF = %+v", coolconf)
+	}
+	if got := comm.registers[TCOOLTHRS]; got != driver.stepper.VelocityToTSTEP(100) {
+		t.Errorf("SetCoolStep() TCOOLTHRS = %d", got)
+	}
+	for _, bad := range []CoolStep{{IncreaseBelow: 0, DecreaseAbove: 100}, {IncreaseBelow: 500, DecreaseAbove: 600}, {IncreaseBelow: 96, DecreaseAbove: 96}} {
+		if err = driver.SetCoolStep(bad); err == nil {
+			t.Errorf("SetCoolStep(%+v) succeeded; expected an error", bad)
+		}
+	}
+
+	comm.registers[DRV_STATUS] = 7<<16 | 250
+	var reports []CoolStepStatus
+	err = driver.MonitorCoolStep(WaitOptions{Interval: time.Microsecond}, func(status CoolStepStatus) bool {
+		reports = append(reports, status)
+		return len(reports) < 3
+	})
+	if err != nil || len(reports) != 3 {
+		t.Fatalf("MonitorCoolStep() = %v after %d reports; expected 3 reports", err, len(reports))
+	}
+	if status := reports[0]; status.CsActual != 7 || status.Scale != 0.5 || status.SgResult != 250 {
+		t.Errorf("CoolStepStatus() = %+v; expected CsActual 7, Scale 0.5, SgResult 250", status)
+	}
+	opts := WaitOptions{Interval: time.Microsecond, Timeout: time.Millisecond}
+	if err = driver.MonitorCoolStep(opts, func(CoolStepStatus) bool { return true }); err != ErrWaitTimeout {
+		t.Errorf("MonitorCoolStep() = %v; expected %v", err, ErrWaitTimeout)
+	}
+
+	if err = driver.DisableCoolStep(); err != nil || comm.registers[COOLCONF]&0xF != 0 {
+		t.Errorf("DisableCoolStep() did not clear SEMIN")
+	}
+}
+
+func TestDcStep(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	chopconf := NewCHOPCONF()
+	chopconf.Toff = 5
+	chopconf.Tbl = 2
+	driver.WriteRegister(CHOPCONF, chopconf.Pack())
+
+	if err := driver.SetDcStep(DcStep{MinSpeed: 500, Velocity: 1000}); err != nil {
+		t.Fatalf("SetDcStep() error: %v", err)
+	}
+	dcctrl := NewDCCTRL()
+	dcctrl.Unpack(comm.registers[DCCTRL])
+	if dcctrl.DcTime != 37 || dcctrl.DcSg != 187 {
+		t.Errorf("SetDcStep() DC_TIME, DC_SG = %d, %d; expected 37, 187", dcctrl.DcTime, dcctrl.DcSg)
+	}
+	if comm.registers[VDCMIN] != driver.stepper.VelocityToVMAX(500) || comm.registers[THIGH] != driver.stepper.VelocityToTSTEP(500) {
+		t.Errorf("SetDcStep() VDCMIN, THIGH = %d, %d", comm.registers[VDCMIN], comm.registers[THIGH])
+	}
+	chopconf.Unpack(comm.registers[CHOPCONF])
+	if !chopconf.Vhighfs || !chopconf.Vhighchm || chopconf.Toff != 5 {
+		t.Errorf("SetDcStep() CHOPCONF = %x; expected fullstep switching with TOFF kept", comm.registers[CHOPCONF])
+	}
+
+	// At high velocity DC_SG stays slightly above DC_TIME/16
+	if err := driver.SetDcStep(DcStep{MinSpeed: 500, Velocity: 200000}); err != nil {
+		t.Fatalf("SetDcStep() error: %v", err)
+	}
+	if dcctrl.Unpack(comm.registers[DCCTRL]); dcctrl.DcSg != 3 {
+		t.Errorf("SetDcStep() DC_SG = %d; expected 3", dcctrl.DcSg)
+	}
+	if err := driver.SetDcStep(DcStep{}); err == nil {
+		t.Errorf("SetDcStep() without speed succeeded; expected an error")
+	}
+
+	comm.registers[LOST_STEPS] = 0xFFFFE
+	if got, err := driver.LostSteps(); err != nil || got != -2 {
+		t.Errorf("LostSteps() = %d, %v; expected -2", got, err)
+	}
+	if err := driver.DisableDcStep(); err != nil || comm.registers[VDCMIN] != 0 {
+		t.Errorf("DisableDcStep() did not clear VDCMIN")
+	}
+}
+
+func TestEncoderConstant(t *testing.T) {
+	tests := []struct {
+		counts     int32
+		microsteps int32
+		value      uint32
+		decimal    bool
+	}{
+		{4000, 3200, 8000, true},        // 0.8 is exact in decimal
+		{4096, 3200, 51200, false},      // 0.78125 is exact in binary
+		{-4000, 3200, 0xFFFF07D0, true}, // -0.8 = -1 + 0.2
+		{1000, 51200, 51<<16 | 2000, true},
+	}
+	for _, tt := range tests {
+		got, err := CalculateEncoderConstant(tt.counts, tt.microsteps)
+		if err != nil {
+			t.Fatalf("CalculateEncoderConstant(%d, %d) error: %v", tt.counts, tt.microsteps, err)
+		}
+		if got.Value != tt.value || got.Decimal != tt.decimal || got.Error != 0 {
+			t.Errorf("CalculateEncoderConstant(%d, %d) = %+v; expected value %x, decimal %v", tt.counts, tt.microsteps, got, tt.value, tt.decimal)
+		}
+	}
+	// 3200/3000 is not exact in either mode; binary is closer
+	got, _ := CalculateEncoderConstant(3000, 3200)
+	if got.Decimal || got.Value != 69905 || !approxEqual(got.Error, -0.00305, 0.00001) {
+		t.Errorf("CalculateEncoderConstant(3000, 3200) = %+v; expected binary 69905", got)
+	}
+	if _, err := CalculateEncoderConstant(0, 3200); err == nil {
+		t.Errorf("CalculateEncoderConstant(0, 3200) succeeded; expected an error")
+	}
+
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	driver.SetAxis(NewLeadScrewAxis(nil, 8))
+	if _, err := driver.SetEncoder(4000); err != nil {
+		t.Fatalf("SetEncoder() error: %v", err)
+	}
+	if comm.registers[ENC_CONST] != 8000 || comm.registers[ENCMODE]&(1<<10) == 0 {
+		t.Errorf("SetEncoder() ENC_CONST, ENCMODE = %d, %x; expected decimal 8000", comm.registers[ENC_CONST], comm.registers[ENCMODE])
+	}
+	comm.registers[X_ENC] = MicrostepsToXACTUAL(-800)
+	if got, err := driver.EncoderPosition(); err != nil || got != -2 {
+		t.Errorf("EncoderPosition() = %v, %v; expected -2", got, err)
+	}
+}
+
+func TestSuperviseEncoder(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	if err := driver.SetAcceleration(1000); err != nil {
+		t.Fatalf("SetAcceleration() error: %v", err)
+	}
+	if err := driver.SetEncoderDeviation(2); err != nil || comm.registers[ENC_DEVIATION] != 32 {
+		t.Fatalf("SetEncoderDeviation() = %v, ENC_DEVIATION = %d; expected 32", err, comm.registers[ENC_DEVIATION])
+	}
+
+	opts := WaitOptions{Interval: time.Microsecond, Timeout: time.Millisecond}
+	if err := driver.SuperviseEncoder(EncoderSupervision{Wait: opts}); err != ErrWaitTimeout {
+		t.Errorf("SuperviseEncoder() without deviation = %v; expected %v", err, ErrWaitTimeout)
+	}
+	if err := driver.CheckEncoder(EncoderSupervision{}); err != nil {
+		t.Errorf("CheckEncoder() without deviation = %v", err)
+	}
+
+	comm.registers[ENC_STATUS] = 1 << 1 // deviation_warn
+	comm.registers[RAMP_STAT] = 1 << 10 // vzero
+	comm.registers[XACTUAL] = 3200
+	comm.registers[X_ENC] = 3000
+	err := driver.SuperviseEncoder(EncoderSupervision{Resync: true, Wait: opts})
+	var deviation *DeviationError
+	if !errors.As(err, &deviation) || !errors.Is(err, ErrEncoderDeviation) {
+		t.Fatalf("SuperviseEncoder() = %v; expected a DeviationError", err)
+	}
+	if deviation.Position != 200 || deviation.Encoder != 187.5 || deviation.Deviation() != -12.5 || !deviation.Resynced {
+		t.Errorf("SuperviseEncoder() = %+v; expected position 200, encoder 187.5, resynced", deviation)
+	}
+	if comm.registers[XACTUAL] != 3000 || comm.registers[XTARGET] != 3000 || comm.registers[VMAX] != 0 {
+		t.Errorf("SuperviseEncoder() did not stop and resync XACTUAL to the encoder")
+	}
+	if comm.registers[ENC_STATUS] != 0 {
+		t.Errorf("SuperviseEncoder() did not clear the deviation warning")
+	}
+}
+
+func TestHomeToIndex(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	if err := driver.SetAcceleration(1000); err != nil {
+		t.Fatalf("SetAcceleration() error: %v", err)
+	}
+	driver.WriteRegister(ENCMODE, 1<<10) // Decimal prescaler from SetEncoder
+	indexed := false
+	comm.onWrite = func(register uint8, value uint32) {
+		switch {
+		case register == RAMPMODE && value == uint32(VelocityPositiveMode) && !indexed:
+			indexed = true
+			// Index seen at XACTUAL 1000 / X_ENC 1010, stopped at 1100 / 1108
+			comm.registers[XLATCH] = 1000
+			comm.registers[ENC_LATCH] = 1010
+			comm.registers[XACTUAL] = 1100
+			comm.registers[X_ENC] = 1108
+			comm.registers[ENC_STATUS] |= 1<<0 | 1<<1 // n_event, deviation_warn
+		case register == VMAX && value == 0:
+			comm.registers[RAMP_STAT] |= 1 << 10 // vzero
+		}
+	}
+
+	err := driver.HomeToIndex(IndexHoming{Direction: HomePositive, Speed: 10, ActiveHigh: true, IgnoreAB: true, HomePosition: 1})
+	if err != nil {
+		t.Fatalf("HomeToIndex() error: %v", err)
+	}
+	encmode := NewENCMODE()
+	encmode.Unpack(comm.written(ENCMODE)[1])
+	if !encmode.ClrOnce || !encmode.LatchXAct || encmode.ClrEncX || !encmode.PolN || !encmode.IgnoreAB || !encmode.EncSelDecimal {
+		t.Errorf("HomeToIndex() ENCMODE = %+v; expected a one-shot N latch with XACTUAL", encmode)
+	}
+	if XACTUALToMicrosteps(comm.registers[XACTUAL]) != 116 || int32(comm.registers[X_ENC]) != 114 {
+		t.Errorf("HomeToIndex() XACTUAL, X_ENC = %d, %d; expected 116, 114", int32(comm.registers[XACTUAL]), int32(comm.registers[X_ENC]))
+	}
+	if comm.registers[ENCMODE] != 1<<10 || comm.registers[ENC_STATUS] != 1<<1 {
+		t.Errorf("HomeToIndex() ENC_STATUS = %b; expected ENCMODE restored, the N event cleared and the deviation warning kept", comm.registers[ENC_STATUS])
+	}
+}
+
+// movingComm is a fakeComm where moves in positioning mode advance by a fixed distance on each XACTUAL read
+type movingComm struct {
+	*fakeComm
+	speed int32 // Microsteps per XACTUAL read
+}
+
+func (m *movingComm) ReadRegister(register uint8, driverIndex uint8) (uint32, error) {
+	if register == XACTUAL && m.registers[RAMPMODE] == uint32(PositioningMode) {
+		xactual, xtarget := int32(m.registers[XACTUAL]), int32(m.registers[XTARGET])
+		m.registers[XACTUAL] = uint32(constrain(xtarget, xactual-m.speed, xactual+m.speed))
+	}
+	return m.fakeComm.ReadRegister(register, driverIndex)
+}
+
+func (m *movingComm) WriteRegister(register uint8, value uint32, driverIndex uint8) error {
+	if register == XTARGET {
+		m.writes = append(m.writes, fakeWrite{register, value})
+		m.registers[XTARGET] = value
+		return nil
+	}
+	return m.fakeComm.WriteRegister(register, value, driverIndex)
+}
+
+func TestPositionCompare(t *testing.T) {
+	comm := &movingComm{fakeComm: newFakeComm(), speed: 100}
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	gconf := NewGCONF()
+	gconf.Diag1StallDir = true
+	gconf.EnPwmMode = true
+	driver.WriteRegister(GCONF, gconf.Pack())
+	if err := driver.SetRamp(RampProfile{AMax: 1000, VMax: 1000}); err != nil {
+		t.Fatalf("SetRamp() error: %v", err)
+	}
+
+	if err := driver.SetPositionCompare(10, true); err != nil {
+		t.Fatalf("SetPositionCompare() error: %v", err)
+	}
+	gconf.Unpack(comm.registers[GCONF])
+	if gconf.Diag1StallDir || !gconf.Diag1PosCompPushPull || !gconf.EnPwmMode || comm.registers[X_COMPARE] != 160 {
+		t.Errorf("SetPositionCompare() GCONF, X_COMPARE = %x, %d", comm.registers[GCONF], comm.registers[X_COMPARE])
+	}
+
+	opts := WaitOptions{Interval: time.Microsecond, Timeout: time.Second}
+	if err := driver.MoveTo(100); err != nil {
+		t.Fatalf("MoveTo() error: %v", err)
+	}
+	hits, err := driver.RunCompareSequence(CompareSequence{Start: 20, Step: 25, Count: 3, Wait: opts})
+	if err != nil || hits != 3 {
+		t.Fatalf("RunCompareSequence() = %d, %v; expected 3 hits", hits, err)
+	}
+	if got := comm.written(X_COMPARE); len(got) != 4 || got[1] != 320 || got[2] != 720 || got[3] != 1120 {
+		t.Errorf("RunCompareSequence() X_COMPARE writes = %d; expected 320, 720, 1120", got[1:])
+	}
+
+	var crossings []float32
+	trigger := driver.NewPositionTrigger()
+	for _, position := range []float32{-5, 30, 0, 120} {
+		trigger.Add(position, func(position float32) { crossings = append(crossings, position) })
+	}
+	if err = driver.MoveTo(-10); err != nil {
+		t.Fatalf("MoveTo() error: %v", err)
+	}
+	if err = trigger.Watch(opts); err != nil {
+		t.Fatalf("Watch() error: %v", err)
+	}
+	if len(crossings) != 3 || crossings[0] != 30 || crossings[1] != 0 || crossings[2] != -5 {
+		t.Errorf("Watch() crossings = %v; expected 30, 0, -5", crossings)
+	}
+}
+
+func TestMicrostepTable(t *testing.T) {
+	defaults := DefaultMicrostepTable()
+	sine := SineMicrostepTable()
+	for i := range sine {
+		if d := int(defaults[i]) - int(sine[i]); d < -1 || d > 1 {
+			t.Errorf("SineMicrostepTable()[%d] = %d; expected within 1 of the power-on value %d", i, sine[i], defaults[i])
+		}
+	}
+
+	for _, table := range []MicrostepTable{defaults, sine} {
+		regs, err := table.Encode()
+		if err != nil {
+			t.Fatalf("Encode() error: %v", err)
+		}
+		if got := regs.Decode(); got != table {
+			t.Errorf("Decode(Encode()) does not return the table")
+		}
+	}
+
+	// A table with a lower amplitude and an offset at the zero crossing
+	var custom MicrostepTable
+	for i := range custom {
+		custom[i] = uint8(8 + math.Round(200*math.Sin(math.Pi/2*float64(i)/256)))
+	}
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	if driver.MicrostepTable() != defaults {
+		t.Errorf("MicrostepTable() before SetMicrostepTable() is not the power-on table")
+	}
+	if err := driver.SetMicrostepTable(custom); err != nil {
+		t.Fatalf("SetMicrostepTable() error: %v", err)
+	}
+	if driver.MicrostepTable() != custom {
+		t.Errorf("MicrostepTable() does not return the written table")
+	}
+	if comm.registers[MSLUTSTART] != 208<<16|8 {
+		t.Errorf("SetMicrostepTable() MSLUTSTART = %x; expected START_SIN 8, START_SIN90 208", comm.registers[MSLUTSTART])
+	}
+
+	bad := sine
+	bad[100] += 5
+	if _, err := bad.Encode(); err == nil {
+		t.Errorf("Encode() of a table with a step of 5 succeeded; expected an error")
+	}
+	// Blocks of +2 and -1 steps cannot share a segment
+	var zigzag MicrostepTable
+	for i := 1; i < len(zigzag); i++ {
+		if i/20%2 == 0 {
+			zigzag[i] = zigzag[i-1] + 2
+		} else {
+			zigzag[i] = zigzag[i-1] - 1
+		}
+	}
+	if _, err := zigzag.Encode(); err == nil {
+		t.Errorf("Encode() of a table needing more than four segments succeeded; expected an error")
+	}
+}
+
+func TestDirectMode(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	gconf := NewGCONF()
+	gconf.EnPwmMode = true
+	driver.WriteRegister(GCONF, gconf.Pack())
+	driver.setRampMode(PositioningMode)
+	comm.registers[XACTUAL] = 1234
+	comm.registers[MSCURACT] = 0x1F4<<16 | 0x0B5 // CUR_A -12, CUR_B 181
+
+	if err := driver.SetCoilCurrents(10, 10); err == nil {
+		t.Errorf("SetCoilCurrents() outside direct mode succeeded; expected an error")
+	}
+	comm.registers[VACTUAL] = 100
+	if err := driver.EnterDirectMode(); err == nil {
+		t.Errorf("EnterDirectMode() while moving succeeded; expected an error")
+	}
+	comm.registers[VACTUAL] = 0
+	if err := driver.EnterDirectMode(); err != nil {
+		t.Fatalf("EnterDirectMode() error: %v", err)
+	}
+	gconf.Unpack(comm.registers[GCONF])
+	if !driver.DirectMode() || !gconf.DirectMode || !gconf.EnPwmMode || driver.RampMode() != HoldMode {
+		t.Errorf("EnterDirectMode() GCONF = %x, RAMPMODE = %d", comm.registers[GCONF], driver.RampMode())
+	}
+	if got := comm.registers[XDIRECT]; got != 0x0B5<<16|0x1F4 {
+		t.Errorf("EnterDirectMode() XDIRECT = %x; expected the actual currents", got)
+	}
+
+	if err := driver.SetCoilCurrents(-255, 100); err != nil || comm.registers[XDIRECT] != 100<<16|0x101 {
+		t.Errorf("SetCoilCurrents(-255, 100) = %v, XDIRECT = %x", err, comm.registers[XDIRECT])
+	}
+	if err := driver.SetCoilCurrents(256, 0); err == nil {
+		t.Errorf("SetCoilCurrents(256, 0) succeeded; expected an error")
+	}
+	if err := driver.SetCurrentVector(90, 0.5); err != nil {
+		t.Fatalf("SetCurrentVector() error: %v", err)
+	}
+	xdirect := NewXDIRECT()
+	xdirect.Unpack(comm.registers[XDIRECT])
+	if xdirect.CoilA != 0 || xdirect.CoilB != 124 {
+		t.Errorf("SetCurrentVector(90, 0.5) = %d, %d; expected 0, 124", xdirect.CoilA, xdirect.CoilB)
+	}
+	if err := driver.SetCurrentVector(225, 1); err != nil {
+		t.Fatalf("SetCurrentVector() error: %v", err)
+	}
+	// 248 * cos(225°) = -175.4, within the error of the tinymath approximation
+	if xdirect.Unpack(comm.registers[XDIRECT]); xdirect.CoilA < -176 || xdirect.CoilA > -175 || xdirect.CoilB != xdirect.CoilA {
+		t.Errorf("SetCurrentVector(225, 1) = %d, %d; expected -175, -175", xdirect.CoilA, xdirect.CoilB)
+	}
+	// Full amplitude stays within the ±248 of normal operation
+	if err := driver.SetCurrentVector(180, 1); err != nil {
+		t.Fatalf("SetCurrentVector() error: %v", err)
+	}
+	if xdirect.Unpack(comm.registers[XDIRECT]); xdirect.CoilA != -248 || xdirect.CoilB != 0 {
+		t.Errorf("SetCurrentVector(180, 1) = %d, %d; expected -248, 0", xdirect.CoilA, xdirect.CoilB)
+	}
+
+	if err := driver.ExitDirectMode(); err != nil {
+		t.Fatalf("ExitDirectMode() error: %v", err)
+	}
+	if driver.DirectMode() || comm.registers[XTARGET] != 1234 {
+		t.Errorf("ExitDirectMode() GCONF = %x, XTARGET = %d; expected direct mode off and XTARGET = XACTUAL", comm.registers[GCONF], comm.registers[XTARGET])
+	}
+}
+
+func TestStepDir(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	chopconf := NewCHOPCONF()
+	chopconf.Toff = 3
+	driver.WriteRegister(CHOPCONF, chopconf.Pack())
+	sd := driver.NewStepDir(machine.NoPin, machine.NoPin, true)
+	if err := sd.Configure(); err != nil {
+		t.Fatalf("Configure() error: %v", err)
+	}
+	chopconf.Unpack(comm.registers[CHOPCONF])
+	if chopconf.Mres != 4 || !chopconf.Intpol || !chopconf.Dedge || chopconf.Toff != 3 {
+		t.Errorf("Configure() CHOPCONF = %x; expected MRES 4, INTPOL and DEDGE", comm.registers[CHOPCONF])
+	}
+	if _, err := microstepsToMres(12); err == nil {
+		t.Errorf("microstepsToMres(12) succeeded; expected an error")
+	}
+
+	if err := sd.MoveTo(10, nil); err == nil {
+		t.Errorf("MoveTo() without speed succeeded; expected an error")
+	}
+	sd.SetSpeed(10000)
+	sd.SetAcceleration(100000)
+	if err := sd.MoveTo(10, nil); err != nil || sd.Position() != 10 {
+		t.Errorf("MoveTo(10) = %v, position %f", err, sd.Position())
+	}
+	if err := sd.MoveBy(-2.5, nil); err != nil || sd.Position() != 7.5 {
+		t.Errorf("MoveBy(-2.5) = %v, position %f", err, sd.Position())
+	}
+	done := make(chan struct{})
+	close(done)
+	if err := sd.MoveBy(100, done); err != ErrWaitCanceled || sd.Position() != 7.5 {
+		t.Errorf("MoveBy() canceled = %v, position %f; expected to stop at 7.5", err, sd.Position())
+	}
+	before := sd.position
+	if err := driver.SetMicrosteps(256); err != nil {
+		t.Fatalf("SetMicrosteps(256) error: %v", err)
+	}
+	if sd.Position() != 7.5 || sd.position != before*16 {
+		t.Errorf("SetMicrosteps(256) position = %f (%d microsteps); expected 7.5", sd.Position(), sd.position)
+	}
+
+	profile := stepProfile{vmax: 1000, accel: 10000, total: 200}
+	if first, cruise := profile.interval(0), profile.interval(100); first <= cruise || cruise != time.Millisecond {
+		t.Errorf("interval() = %v at the start, %v cruising", first, cruise)
+	}
+	if profile.interval(10) != profile.interval(189) {
+		t.Errorf("interval() not symmetric: %v, %v", profile.interval(10), profile.interval(189))
+	}
+	if stop := profile.stopSteps(101); stop != 50 {
+		t.Errorf("stopSteps() = %d; expected 50", stop)
+	}
+
+	// Canceling while decelerating must not run past the target
+	for i := int32(1); i < 1000; i++ {
+		profile = stepProfile{vmax: 3200, accel: 16000, total: 1000}
+		if profile.stop(i); profile.total > 1000 || profile.total < i {
+			t.Fatalf("stop(%d) ends the move at %d; expected %d..1000", i, profile.total, i)
+		}
+	}
+}
+
+func TestSetMicrosteps(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	driver.SetSpeed(100)
+	driver.SetAcceleration(1000)
+	driver.MoveTo(10)
+	driver.WriteRegister(X_COMPARE, MicrostepsToXACTUAL(-48))
+	driver.WriteRegister(TPWMTHRS, 500)
+	driver.SetEncoder(4000)
+	comm.registers[X_ENC] = 164
+	vmax, amax := comm.registers[VMAX], comm.registers[AMAX]
+
+	comm.registers[VACTUAL] = 1
+	if err := driver.SetMicrosteps(64); err == nil {
+		t.Errorf("SetMicrosteps() while moving succeeded; expected an error")
+	}
+	comm.registers[VACTUAL] = 0
+	if err := driver.SetMicrosteps(48); err == nil {
+		t.Errorf("SetMicrosteps(48) succeeded; expected an error")
+	}
+	if err := driver.SetMicrosteps(256); err != nil {
+		t.Fatalf("SetMicrosteps(256) error: %v", err)
+	}
+	chopconf := NewCHOPCONF()
+	chopconf.Unpack(comm.registers[CHOPCONF])
+	if chopconf.Mres != 0 || !chopconf.Intpol || driver.Microsteps() != 256 {
+		t.Errorf("SetMicrosteps(256) MRES = %d, Microsteps() = %d", chopconf.Mres, driver.Microsteps())
+	}
+	if !driver.positionKnown || driver.position != 2560 {
+		t.Errorf("SetMicrosteps(256) left %d as the position for the reset recovery; expected 2560", driver.position)
+	}
+	if position, _ := driver.Position(); position != 10 || comm.registers[XACTUAL] != 2560 || comm.registers[XTARGET] != 2560 {
+		t.Errorf("SetMicrosteps(256) position = %f, XACTUAL = %d", position, comm.registers[XACTUAL])
+	}
+	if got := XACTUALToMicrosteps(comm.registers[X_COMPARE]); got != -768 {
+		t.Errorf("SetMicrosteps(256) X_COMPARE = %d; expected -768", got)
+	}
+	if comm.registers[VMAX] != vmax*16 || comm.registers[AMAX] != amax*16 || comm.registers[TPWMTHRS] != 500 {
+		t.Errorf("SetMicrosteps(256) VMAX = %d, AMAX = %d, TPWMTHRS = %d", comm.registers[VMAX], comm.registers[AMAX], comm.registers[TPWMTHRS])
+	}
+	if encoder, _ := CalculateEncoderConstant(4000, 51200); comm.registers[ENC_CONST] != encoder.Value || comm.registers[X_ENC] != 164*16 {
+		t.Errorf("SetMicrosteps(256) ENC_CONST = %x, X_ENC = %d", comm.registers[ENC_CONST], comm.registers[X_ENC])
+	}
+	if driver.RampMode() != PositioningMode {
+		t.Errorf("SetMicrosteps() left ramp mode %d", driver.RampMode())
+	}
+
+	// Small velocities and accelerations do not round down to 0, VSTOP follows the rules of stopVelocity
+	driver.WriteRegister(D_1, 100)
+	driver.WriteRegister(VSTART, 200)
+	driver.WriteRegister(VSTOP, 210)
+	if err := driver.SetMicrosteps(1); err != nil {
+		t.Fatalf("SetMicrosteps(1) error: %v", err)
+	}
+	if comm.registers[D_1] != 1 || comm.registers[VSTART] != 1 || comm.registers[VSTOP] != minVSTOP {
+		t.Errorf("SetMicrosteps(1) D1 = %d, VSTART = %d, VSTOP = %d; expected 1, 1, %d", comm.registers[D_1], comm.registers[VSTART], comm.registers[VSTOP], minVSTOP)
+	}
+	driver.SetMicrosteps(2)
+	driver.WriteRegister(VSTART, 31)
+	driver.WriteRegister(VSTOP, 32)
+	if err := driver.SetMicrosteps(1); err != nil || comm.registers[VSTOP] <= comm.registers[VSTART] {
+		t.Errorf("SetMicrosteps(1) = %v, VSTART = %d, VSTOP = %d; expected VSTOP above VSTART", err, comm.registers[VSTART], comm.registers[VSTOP])
+	}
+
+	if err := driver.EnterDirectMode(); err != nil {
+		t.Fatalf("EnterDirectMode() error: %v", err)
+	}
+	xdirect := comm.registers[XDIRECT]
+	if err := driver.SetMicrosteps(16); err == nil || comm.registers[XDIRECT] != xdirect {
+		t.Errorf("SetMicrosteps() in direct mode = %v, XDIRECT = %x; expected an error and the currents kept", err, comm.registers[XDIRECT])
+	}
+
+	if got, _ := scaleEncoderConstant(0x00021388, true, 1, 2); got != 0x000109C4 {
+		t.Errorf("scaleEncoderConstant(2.5 decimal, 1/2) = %x; expected 1.25 (0x109C4)", got)
+	}
+}
+
+func TestMonitorHealth(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	drvStatus := NewDRV_STATUS()
+	drvStatus.Otpw = true
+	drvStatus.Ola = true
+	drvStatus.Stst = true
+	comm.registers[DRV_STATUS] = drvStatus.Pack()
+	gstat := NewGSTAT()
+	gstat.Reset = true
+	comm.registers[GSTAT] = gstat.Pack()
+
+	status, err := driver.ReadHealth(false)
+	if err != nil || status != 1<<OvertemperatureWarning|1<<ChipReset {
+		t.Errorf("ReadHealth(false) = %b, %v", status, err)
+	}
+	if status, _ = driver.ReadHealth(true); !status.Has(OpenLoadA) || status.Faults() != 1<<ChipReset {
+		t.Errorf("ReadHealth(true) = %b", status)
+	}
+
+	var events []HealthEvent
+	err = driver.MonitorHealth(HealthMonitoring{Wait: WaitOptions{Interval: time.Millisecond}}, func(event HealthEvent) bool {
+		events = append(events, event)
+		if len(events) == 2 {
+			// Short circuit while moving, the warning clears
+			drvStatus.Stst = false
+			drvStatus.Otpw = false
+			drvStatus.S2gb = true
+			comm.registers[DRV_STATUS] = drvStatus.Pack()
+		}
+		return len(events) < 5
+	})
+	if err != nil {
+		t.Fatalf("MonitorHealth() error: %v", err)
+	}
+	expected := []struct {
+		condition HealthCondition
+		active    bool
+	}{{OvertemperatureWarning, true}, {ChipReset, true}, {OvertemperatureWarning, false}, {ShortToGroundB, true}, {OpenLoadA, true}}
+	for i, event := range events {
+		if event.Condition != expected[i].condition || event.Active != expected[i].active || event.Time.IsZero() {
+			t.Errorf("event %d = %v %v; expected %v %v", i, event.Condition, event.Active, expected[i].condition, expected[i].active)
+		}
+	}
+	if ShortToGroundB.Severity() != HealthFault || !ShortToGroundB.Latched() || OpenLoadA.Latched() {
+		t.Errorf("ShortToGroundB must be a latched fault and OpenLoadA a transient warning")
+	}
+
+	// Single-step checks resume with the changes report did not take
+	monitor := driver.NewHealthMonitor(HealthMonitoring{})
+	events = nil
+	if more, err := monitor.Check(func(event HealthEvent) bool { events = append(events, event); return false }); more || err != nil || len(events) != 1 {
+		t.Errorf("Check() = %v, %v with %d events; expected to stop after 1", more, err, len(events))
+	}
+	if more, err := monitor.Check(func(event HealthEvent) bool { events = append(events, event); return true }); !more || err != nil || len(events) != 3 {
+		t.Errorf("Check() = %v, %v with %d events; expected 3", more, err, len(events))
+	}
+	if more, _ := monitor.Check(func(HealthEvent) bool { t.Errorf("Check() reported an unchanged condition"); return true }); !more {
+		t.Errorf("Check() without changes = false")
+	}
+}
+
+func TestSuperviseFaults(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	chopconf := NewCHOPCONF()
+	chopconf.Toff = 5
+	chopconf.Tbl = 2
+	driver.WriteRegister(CHOPCONF, chopconf.Pack())
+	driver.WriteRegister(IHOLD_IRUN, 0x00071F10)
+
+	// A short to ground that clears when the stage is switched off and comes back for the first two attempts
+	shortCircuit := func() {
+		drvStatus := NewDRV_STATUS()
+		drvStatus.S2ga = true
+		comm.registers[DRV_STATUS] = drvStatus.Pack()
+		comm.registers[GSTAT] |= 1 << 1 // drv_err
+	}
+	failures := 2
+	comm.onWrite = func(register uint8, value uint32) {
+		if register != CHOPCONF {
+			return
+		}
+		if value&0xF == 0 {
+			comm.registers[DRV_STATUS] = 0
+		} else if failures > 0 {
+			failures--
+			shortCircuit()
+		}
+	}
+	shortCircuit()
+
+	var attempts []RecoveryAttempt
+	cfg := FaultRecovery{
+		Short:  RecoveryPolicy{MaxRetries: 3, Delay: time.Millisecond, MaxDelay: 3 * time.Millisecond},
+		Report: func(attempt RecoveryAttempt) { attempts = append(attempts, attempt) },
+		Wait:   WaitOptions{Interval: time.Millisecond, Timeout: 50 * time.Millisecond},
+	}
+	if err := driver.SuperviseFaults(cfg); err != ErrWaitTimeout {
+		t.Fatalf("SuperviseFaults() = %v; expected a timeout after the recovery", err)
+	}
+	if len(attempts) != 3 || attempts[0].Faults == 0 || attempts[2].Faults != 0 || attempts[2].Class != FaultShort {
+		t.Fatalf("SuperviseFaults() attempts = %+v; expected success on the third", attempts)
+	}
+	if attempts[0].Delay != time.Millisecond || attempts[1].Delay != 2*time.Millisecond || attempts[2].Delay != 3*time.Millisecond {
+		t.Errorf("SuperviseFaults() delays = %v, %v, %v", attempts[0].Delay, attempts[1].Delay, attempts[2].Delay)
+	}
+	if comm.registers[CHOPCONF] != chopconf.Pack() || comm.registers[GSTAT] != 0 || comm.registers[IHOLD_IRUN] != 0x00071F10 {
+		t.Errorf("SuperviseFaults() CHOPCONF = %x, GSTAT = %x; expected the configuration back", comm.registers[CHOPCONF], comm.registers[GSTAT])
+	}
+
+	// The short persists: give up after MaxRetries
+	failures = 10
+	shortCircuit()
+	attempts = nil
+	err := driver.SuperviseFaults(cfg)
+	var faultErr *FaultError
+	if !errors.As(err, &faultErr) || !errors.Is(err, ErrPermanentFault) || faultErr.Attempts != 3 || !faultErr.Faults.Has(ShortToGroundA) {
+		t.Fatalf("SuperviseFaults() = %v; expected a permanent fault after 3 attempts", err)
+	}
+	if comm.registers[CHOPCONF]&0xF != 0 || !driver.PermanentFault().Has(ShortToGroundA) {
+		t.Errorf("permanent fault: CHOPCONF = %x; expected the stage off", comm.registers[CHOPCONF])
+	}
+	if got := driver.shadowValue(CHOPCONF); got != chopconf.Pack() {
+		t.Errorf("permanent fault: CHOPCONF shadow = %x; expected the configured %x", got, chopconf.Pack())
+	}
+	if err = driver.SuperviseFaults(cfg); !errors.Is(err, ErrPermanentFault) {
+		t.Errorf("SuperviseFaults() in the permanent fault state = %v", err)
+	}
+	// A reset does not switch the stage back on
+	comm.registers[GSTAT] |= 1
+	comm.registers[CHOPCONF] = 0
+	if reset, err := driver.CheckReset(); !reset || err != nil || comm.registers[CHOPCONF]&0xF != 0 || comm.registers[CHOPCONF] == 0 {
+		t.Errorf("CheckReset() in the permanent fault state = %v, %v, CHOPCONF = %x; expected the stage off", reset, err, comm.registers[CHOPCONF])
+	}
+	failures = 0
+	if err = driver.ClearPermanentFault(); err != nil || driver.PermanentFault() != 0 || comm.registers[CHOPCONF] != chopconf.Pack() {
+		t.Errorf("ClearPermanentFault() = %v, CHOPCONF = %x", err, comm.registers[CHOPCONF])
+	}
+
+	// Single-step checks
+	if err = driver.CheckFaults(cfg); err != nil {
+		t.Errorf("CheckFaults() without faults = %v", err)
+	}
+	shortCircuit()
+	attempts = nil
+	if err = driver.CheckFaults(cfg); err != nil || len(attempts) != 1 || comm.registers[CHOPCONF] != chopconf.Pack() {
+		t.Errorf("CheckFaults() = %v after %d attempts, CHOPCONF = %x", err, len(attempts), comm.registers[CHOPCONF])
+	}
+
+	// Canceling while the stage is off keeps the configuration for ClearPermanentFault
+	done := make(chan struct{})
+	close(done)
+	cfg.Wait.Done = done
+	shortCircuit()
+	if err = driver.CheckFaults(cfg); err != ErrWaitCanceled || !driver.PermanentFault().Has(ShortToGroundA) {
+		t.Errorf("CheckFaults() canceled = %v, PermanentFault() = %b", err, driver.PermanentFault())
+	}
+	if err = driver.ClearPermanentFault(); err != nil || comm.registers[CHOPCONF] != chopconf.Pack() {
+		t.Errorf("ClearPermanentFault() after a cancel = %v, CHOPCONF = %x", err, comm.registers[CHOPCONF])
+	}
+}
+
+func TestResetRestore(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	driver.WriteRegister(CHOPCONF, 0x10410155)
+	driver.WriteRegister(IHOLD_IRUN, 0x00071F10)
+	driver.WriteRegister(PWMCONF, 0xC40C001E)
+	driver.WriteRegister(COOLCONF, 0x0005)
+	driver.WriteRegister(OTP_PROG, 0x1234)
+	driver.SetSpeed(100)
+	driver.SetAcceleration(1000)
+	driver.SetEncoder(4000)
+	driver.Position()
+	driver.MoveTo(10)
+	comm.registers[RAMP_STAT] = 1<<9 | 1<<10 // position_reached, vzero
+	if err := driver.WaitPositionReached(WaitOptions{Interval: time.Millisecond}); err != nil {
+		t.Fatalf("WaitPositionReached() error: %v", err)
+	}
+	vmax := comm.registers[VMAX]
+	var events []ResetEvent
+	driver.OnReset(func(event ResetEvent) { events = append(events, event) })
+
+	if reset, err := driver.CheckReset(); reset || err != nil {
+		t.Errorf("CheckReset() = %v, %v without a reset", reset, err)
+	}
+
+	// Brown-out: every register back to 0 and the reset flag set
+	comm.registers = map[uint8]uint32{GSTAT: 1}
+	comm.writes = nil
+	if reset, err := driver.CheckReset(); !reset || err != nil {
+		t.Fatalf("CheckReset() = %v, %v; expected a reset", reset, err)
+	}
+	if comm.registers[CHOPCONF] != 0x10410155 || comm.registers[IHOLD_IRUN] != 0x00071F10 || comm.registers[VMAX] != vmax {
+		t.Errorf("CheckReset() CHOPCONF = %x, IHOLD_IRUN = %x, VMAX = %d", comm.registers[CHOPCONF], comm.registers[IHOLD_IRUN], comm.registers[VMAX])
+	}
+	if len(comm.written(OTP_PROG)) != 0 || comm.registers[GSTAT] != 0 {
+		t.Errorf("CheckReset() wrote OTP_PROG or left GSTAT = %x", comm.registers[GSTAT])
+	}
+	// CHOPCONF enables the power stage, so it comes after the rest of the configuration
+	enabled := false
+	for _, w := range comm.writes {
+		if w.register == CHOPCONF {
+			enabled = true
+		} else if enabled && (w.register == PWMCONF || w.register == COOLCONF || w.register == IHOLD_IRUN) {
+			t.Errorf("CheckReset() wrote %x after CHOPCONF", w.register)
+		}
+	}
+	if comm.registers[XACTUAL] != 160 || comm.registers[XTARGET] != 160 || comm.registers[X_ENC] != 160 || driver.RampMode() != PositioningMode {
+		t.Errorf("CheckReset() XACTUAL = %d, XTARGET = %d, X_ENC = %d", comm.registers[XACTUAL], comm.registers[XTARGET], comm.registers[X_ENC])
+	}
+	if len(events) != 1 || !events[0].PositionRestored || events[0].Position != 10 || events[0].Err != nil {
+		t.Errorf("OnReset events = %+v", events)
+	}
+
+	// A reset while waiting ends the wait, a jog is not restarted
+	driver.Jog(50)
+	comm.registers = map[uint8]uint32{GSTAT: 1}
+	err := driver.WaitVelocityReached(WaitOptions{Interval: time.Millisecond})
+	if !errors.Is(err, ErrChipReset) || len(events) != 2 {
+		t.Errorf("WaitVelocityReached() = %v after a reset; expected %v", err, ErrChipReset)
+	}
+	if comm.registers[VMAX] != 0 || comm.registers[CHOPCONF] != 0x10410155 {
+		t.Errorf("reset in velocity mode: VMAX = %d; expected 0", comm.registers[VMAX])
+	}
+
+	// A position read before a motion command is stale, XACTUAL stays at 0 and XTARGET follows it
+	driver.Position()
+	driver.MoveTo(20)
+	comm.registers = map[uint8]uint32{GSTAT: 1}
+	if reset, err := driver.CheckReset(); !reset || err != nil {
+		t.Fatalf("CheckReset() = %v, %v; expected a reset", reset, err)
+	}
+	if events[2].PositionRestored || comm.registers[XACTUAL] != 0 || comm.registers[XTARGET] != 0 {
+		t.Errorf("reset during a move: event %+v, XACTUAL = %d, XTARGET = %d", events[2], comm.registers[XACTUAL], comm.registers[XTARGET])
+	}
+
+	// A position read during a move is stale as well
+	status := &statusComm{fakeComm: comm}
+	driver = NewDriver(status, 0, machine.NoPin, NewDefaultStepper())
+	driver.OnReset(func(event ResetEvent) { events = append(events, event) })
+	driver.MoveTo(20)
+	comm.registers[XACTUAL] = 100
+	comm.registers[RAMP_STAT] = 0 // moving
+	driver.Position()
+	comm.registers = map[uint8]uint32{GSTAT: 1}
+	if reset, err := driver.CheckReset(); !reset || err != nil {
+		t.Fatalf("CheckReset() = %v, %v; expected a reset", reset, err)
+	}
+	if events[3].PositionRestored || comm.registers[XACTUAL] != 0 {
+		t.Errorf("reset after a read during a move: event %+v, XACTUAL = %d", events[3], comm.registers[XACTUAL])
+	}
+
+	// Position marks the position known when the SPI status shows standstill
+	comm.registers[XACTUAL] = 48
+	status.status = SPIStatusStandstill
+	driver.Position()
+	comm.registers = map[uint8]uint32{GSTAT: 1}
+	if reset, err := driver.CheckReset(); !reset || err != nil {
+		t.Fatalf("CheckReset() = %v, %v; expected a reset", reset, err)
+	}
+	if !events[4].PositionRestored || events[4].Position != 3 || comm.registers[XACTUAL] != 48 {
+		t.Errorf("reset after a read at standstill: event %+v, XACTUAL = %d", events[4], comm.registers[XACTUAL])
+	}
+}
+
+// statusComm adds the SPI status byte to fakeComm, with the reset flag following GSTAT.reset
+type statusComm struct {
+	*fakeComm
+	status uint8
+}
+
+func (comm *statusComm) Status(driverAddress uint8) uint8 {
+	return comm.status | uint8(comm.registers[GSTAT]&1)*SPIStatusResetFlag
+}
+
+func TestBeginCurrents(t *testing.T) {
+	comm := newFakeComm()
+	driver := NewDriver(comm, 0, machine.NoPin, NewDefaultStepper())
+	if !driver.Begin(PowerStageParameters{}, MotorParameters{globalScaler: 128, ihold: 8, irun: 20}, Clockwise) {
+		t.Fatalf("Begin() failed")
+	}
+	iholdrun := NewIHOLD_IRUN()
+	iholdrun.Unpack(comm.registers[IHOLD_IRUN])
+	if iholdrun.Ihold != 8 || iholdrun.Irun != 20 {
+		t.Errorf("Begin() IHOLD = %d, IRUN = %d; expected 8, 20", iholdrun.Ihold, iholdrun.Irun)
+	}
+}
@@ -0,0 +1,177 @@
+package tmc5160
+
+import (
+	"time"
+)
+
+// HealthSeverity classifies a HealthCondition
+type HealthSeverity uint8
+
+const (
+	HealthWarning HealthSeverity = iota // The driver keeps running, but the condition needs attention
+	HealthFault                         // The power stage is switched off or the configuration is lost
+)
+
+// HealthCondition is a fault or warning flag of DRV_STATUS or GSTAT
+type HealthCondition uint8
+
+const (
+	Overtemperature        HealthCondition = iota // DRV_STATUS.ot, the power stage is switched off
+	OvertemperatureWarning                        // DRV_STATUS.otpw
+	ShortToGroundA                                // DRV_STATUS.s2ga, the power stage is switched off
+	ShortToGroundB                                // DRV_STATUS.s2gb, the power stage is switched off
+	ShortToSupplyA                                // DRV_STATUS.s2vsa, the power stage is switched off
+	ShortToSupplyB                                // DRV_STATUS.s2vsb, the power stage is switched off
+	OpenLoadA                                     // DRV_STATUS.ola
+	OpenLoadB                                     // DRV_STATUS.olb
+	ChipReset                                     // GSTAT.reset, all registers were reset to their defaults
+	DriverError                                   // GSTAT.drv_err, set by overtemperature and short circuits
+	ChargePumpUndervoltage                        // GSTAT.uv_cp, the power stage is switched off
+	numHealthConditions
+)
+
+var healthConditionNames = [numHealthConditions]string{
+	"overtemperature", "overtemperature warning", "short to ground A", "short to ground B",
+	"short to supply A", "short to supply B", "open load A", "open load B",
+	"chip reset", "driver error", "charge pump undervoltage",
+}
+
+func (c HealthCondition) String() string {
+	if c >= numHealthConditions {
+		return "unknown condition"
+	}
+	return healthConditionNames[c]
+}
+
+// Severity returns whether the condition is a warning or a fault
+func (c HealthCondition) Severity() HealthSeverity {
+	switch c {
+	case OvertemperatureWarning, OpenLoadA, OpenLoadB:
+		return HealthWarning
+	}
+	return HealthFault
+}
+
+// Latched reports whether the condition stays active until the driver is re-enabled or GSTAT is cleared.
+// Overtemperature warnings and open load flags clear themselves when the cause goes away.
+func (c HealthCondition) Latched() bool {
+	return c.Severity() == HealthFault
+}
+
+// HealthStatus is a set of active HealthConditions
+type HealthStatus uint16
+
+// Has reports whether a condition is active
+func (s HealthStatus) Has(c HealthCondition) bool {
+	return s&(1<<c) != 0
+}
+
+// Faults returns the active conditions with HealthFault severity
+func (s HealthStatus) Faults() HealthStatus {
+	var faults HealthStatus
+	for c := HealthCondition(0); c < numHealthConditions; c++ {
+		if s.Has(c) && c.Severity() == HealthFault {
+			faults |= 1 << c
+		}
+	}
+	return faults
+}
+
+// HealthEvent reports a condition that became active or cleared
+type HealthEvent struct {
+	Condition HealthCondition
+	Active    bool      // The condition appeared (true) or cleared (false)
+	Time      time.Time // When the change was detected
+}
+
+// Severity returns the severity of the condition
+func (e HealthEvent) Severity() HealthSeverity {
+	return e.Condition.Severity()
+}
+
+// HealthMonitoring configures MonitorHealth
+type HealthMonitoring struct {
+	OpenLoadAtStandstill bool        // Report open load at standstill, where the flags are not reliable
+	Wait                 WaitOptions // Polling interval, timeout and cancellation of the monitor
+}
+
+// ReadHealth reads DRV_STATUS and GSTAT and returns the active conditions.
+// Open load is ignored at standstill unless openLoadAtStandstill is set, as the chopper cannot detect it there.
+func (driver *Driver) ReadHealth(openLoadAtStandstill bool) (HealthStatus, error) {
+	value, err := driver.ReadRegister(DRV_STATUS)
+	if err != nil {
+		return 0, err
+	}
+	drvStatus := NewDRV_STATUS()
+	drvStatus.Unpack(value)
+	if value, err = driver.ReadRegister(GSTAT); err != nil {
+		return 0, err
+	}
+	gstat := NewGSTAT()
+	gstat.Unpack(value)
+
+	openLoad := openLoadAtStandstill || !drvStatus.Stst
+	var status HealthStatus
+	for c, active := range [numHealthConditions]bool{
+		Overtemperature:        drvStatus.Ot,
+		OvertemperatureWarning: drvStatus.Otpw,
+		ShortToGroundA:         drvStatus.S2ga,
+		ShortToGroundB:         drvStatus.S2gb,
+		ShortToSupplyA:         drvStatus.S2vsa,
+		ShortToSupplyB:         drvStatus.S2vsb,
+		OpenLoadA:              drvStatus.Ola && openLoad,
+		OpenLoadB:              drvStatus.Olb && openLoad,
+		ChipReset:              gstat.Reset,
+		DriverError:            gstat.DrvErr,
+		ChargePumpUndervoltage: gstat.UvCp,
+	} {
+		if active {
+			status |= 1 << c
+		}
+	}
+	return status, nil
+}
+
+// HealthMonitor reports the health conditions that changed since its previous check
+type HealthMonitor struct {
+	driver *Driver
+	cfg    HealthMonitoring
+	prev   HealthStatus
+}
+
+// NewHealthMonitor creates a HealthMonitor. Conditions already active are reported by the first Check.
+func (driver *Driver) NewHealthMonitor(cfg HealthMonitoring) *HealthMonitor {
+	return &HealthMonitor{driver: driver, cfg: cfg}
+}
+
+// Check reads DRV_STATUS and GSTAT once and passes an event to report for every condition that became active
+// or cleared. It returns false if report returned false; the remaining changes are reported by the next Check.
+// It does not wait, so a control loop can call it at its own rate.
+func (m *HealthMonitor) Check(report func(HealthEvent) bool) (bool, error) {
+	status, err := m.driver.ReadHealth(m.cfg.OpenLoadAtStandstill)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	for c := HealthCondition(0); c < numHealthConditions; c++ {
+		if status.Has(c) == m.prev.Has(c) {
+			continue
+		}
+		m.prev ^= 1 << c
+		if !report(HealthEvent{Condition: c, Active: status.Has(c), Time: now}) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// MonitorHealth runs a HealthMonitor every cfg.Wait.Interval until report returns false (nil is returned),
+// the timeout expires (ErrWaitTimeout) or cfg.Wait.Done is closed (ErrWaitCanceled). Conditions already active
+// when the monitor starts are reported first.
+func (driver *Driver) MonitorHealth(cfg HealthMonitoring, report func(HealthEvent) bool) error {
+	monitor := driver.NewHealthMonitor(cfg)
+	return poll(cfg.Wait, func() (bool, error) {
+		more, err := monitor.Check(report)
+		return !more, err
+	})
+}
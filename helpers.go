@@ -5,44 +5,50 @@ import (
 	"golang.org/x/exp/constraints"
 )
 
-// VelocityToVMAX calculates the VMAX register value from the current stepper velocity which is  in microsteps per tRef (i.e 1/clock speed)
+// The helpers below take velocities in microsteps per second at the output shaft (scaled by GearRatio).
+// New code should use the full step based conversions in conversions.go.
+
+// CurrentVelocityToVMAX calculates the VMAX register value from the current stepper velocity which is  in microsteps per tRef (i.e 1/clock speed)
 func (stepper *Stepper) CurrentVelocityToVMAX() uint32 {
-	tref := float32(16777216) / (float32(stepper.Fclk) * 1000000)
-	r := stepper.VelocitySPS * stepper.GearRatio * float32(tref)
-	return constrain(uint32(r), 0, maxVMAX) // VMAX register value cannot exceed maxVMAX
+	return stepper.DesiredVelocityToVMAX(stepper.VelocitySPS)
 }
+
+// DesiredVelocityToVMAX calculates the VMAX register value for a velocity in microsteps per second
 func (stepper *Stepper) DesiredVelocityToVMAX(v float32) uint32 {
-	tref := 16777216 / (float32(stepper.Fclk) * 1000000)
+	tref := 16777216 / stepper.fclk()
 	r := tinymath.Round(v * stepper.GearRatio * tref)
-	return constrain(uint32(r), 0, maxVMAX) // VMAX register value cannot exceed maxVMAX
+	return uint32(constrain(r, 0, maxVMAX)) // VMAX register value cannot exceed maxVMAX
 }
 
+// DesiredAccelToAMAX calculates the AMAX register value that reaches dVel (microsteps per second) in dacc seconds.
+//
+// Deprecated: dacc is a ramp time, not an acceleration. Use AccelerationToAMAX.
 func (stepper *Stepper) DesiredAccelToAMAX(dacc float32, dVel float32) uint32 {
+	if dacc <= 0 {
+		return maxAccel
+	}
 	dVelToVMAX := stepper.DesiredVelocityToVMAX(dVel)
 	_a := uint64(dVelToVMAX) * 131072
 	_b := float32(_a) / dacc
-	_c := _b / float32(uint32(stepper.Fclk)*1000000)
-	return uint32(_c)
-
+	_c := _b / stepper.fclk()
+	return uint32(constrain(_c, 0, maxAccel))
 }
 
-// Convert threshold speed (Hz) to internal TSTEP value
+// DesiredSpeedToTSTEP converts a threshold speed in microsteps per second to the internal TSTEP value
 func (stepper *Stepper) DesiredSpeedToTSTEP(thrsSpeed uint32) uint32 {
-	if thrsSpeed < 0 {
-		return 0
-	}
-	_a := stepper.DesiredVelocityToVMAX(float32(thrsSpeed))
-	_b := float32(16777216 / _a)
-	_c := float32(stepper.MSteps) / float32(256)
-	_d := uint32(_b * _c)
-	return constrain(_d, 0, 1048575)
+	return stepper.VMAXToTSTEP(stepper.DesiredVelocityToVMAX(float32(thrsSpeed)))
 }
 
+// VMAXToTSTEP converts a VMAX register value to the TSTEP value measured at that velocity.
+// A VMAX of 0 (standstill) gives the largest TSTEP value.
 func (stepper *Stepper) VMAXToTSTEP(vmax uint32) uint32 {
-	_b := float32(16777216 / vmax)
-	_c := float32(stepper.MSteps) / float32(256)
+	if vmax == 0 {
+		return maxTSTEP
+	}
+	_b := float32(16777216) / float32(vmax)
+	_c := stepper.microsteps() / float32(256)
 	_d := tinymath.Round(_b * _c)
-	return constrain(uint32(_d), 0, 1048575)
+	return uint32(constrain(_d, 0, maxTSTEP))
 }
 
 // Constrain function to limit values to a specific range (supports multiple types).
@@ -0,0 +1,423 @@
+package tmc5160
+
+import (
+	"errors"
+	"time"
+)
+
+// stopTimeout bounds the wait for standstill before a routine restores the registers it changed
+const stopTimeout = 5 * time.Second
+
+// HomingDirection selects the direction in which a homing routine searches for the end of travel
+type HomingDirection uint8
+
+const (
+	HomeNegative HomingDirection = iota // Towards decreasing positions
+	HomePositive                        // Towards increasing positions
+)
+
+// DefaultHomingBackoff is the distance in full steps moved away from the end of travel when HomingOptions.Backoff is not set
+const DefaultHomingBackoff = 20
+
+// HomingOptions configures the homing routines
+type HomingOptions struct {
+	Backoff float32     // Distance in axis units moved away from the end of travel once found (0 = DefaultHomingBackoff full steps)
+	Wait    WaitOptions // Polling, timeout and cancellation of each homing move
+}
+
+// signed returns v with the sign of the homing direction
+func (direction HomingDirection) signed(v float32) float32 {
+	if direction == HomeNegative {
+		return -v
+	}
+	return v
+}
+
+// backoff returns the signed back-off distance in axis units, pointing away from the end of travel
+func (driver *Driver) backoff(direction HomingDirection, opts HomingOptions) float32 {
+	distance := opts.Backoff
+	if distance <= 0 {
+		distance = driver.axis.FromSteps(DefaultHomingBackoff)
+	}
+	return -direction.signed(distance)
+}
+
+// HomeSensorless homes the axis against a mechanical end stop using stallGuard2.
+// See HomeSensorlessWithOptions.
+func (driver *Driver) HomeSensorless(direction HomingDirection, speed float32, sgt int8) error {
+	return driver.HomeSensorlessWithOptions(direction, speed, sgt, HomingOptions{})
+}
+
+// HomeSensorlessWithOptions homes the axis against a mechanical end stop using stallGuard2.
+// It switches to spreadCycle, enables stallGuard2 above 3/4 of the homing speed (in units/s) with the given
+// threshold, runs in velocity mode until the stallGuard2 stop, backs off and sets XACTUAL to 0.
+// GCONF, TCOOLTHRS, COOLCONF and SW_MODE are restored afterwards, also when homing fails.
+// An acceleration must have been set with SetAcceleration or SetRamp.
+func (driver *Driver) HomeSensorlessWithOptions(direction HomingDirection, speed float32, sgt int8, opts HomingOptions) (err error) {
+	if speed <= 0 {
+		return CustomError("homing speed must be greater than 0")
+	}
+	if sgt < -64 || sgt > 63 {
+		return CustomError("sgt out of range (-64..63)")
+	}
+	saved := driver.saveRegisters(GCONF, TCOOLTHRS, COOLCONF, SW_MODE)
+	vmax := driver.ramp.VMax
+	defer func() {
+		if restoreErr := driver.finishRun(saved, vmax); err == nil {
+			err = restoreErr
+		}
+	}()
+
+	// stallGuard2 only works in spreadCycle
+	gconf := NewGCONF()
+	gconf.Unpack(driver.shadowValue(GCONF))
+	gconf.EnPwmMode = false
+	if err = driver.WriteRegister(GCONF, gconf.Pack()); err != nil {
+		return err
+	}
+	// Keep stallGuard2 off while accelerating, its readings are not valid at low velocity
+	if err = driver.WriteRegister(TCOOLTHRS, driver.axis.VelocityToTSTEP(speed*3/4)); err != nil {
+		return err
+	}
+	coolconf := NewCOOLCONF()
+	coolconf.Unpack(driver.shadowValue(COOLCONF))
+	coolconf.Sgt = uint8(sgt) & 0x7F
+	if err = driver.WriteRegister(COOLCONF, coolconf.Pack()); err != nil {
+		return err
+	}
+	swMode := NewSW_MODE()
+	swMode.Unpack(driver.shadowValue(SW_MODE))
+	swMode.SgStop = true
+	if err = driver.WriteRegister(SW_MODE, swMode.Pack()); err != nil {
+		return err
+	}
+	if err = driver.ClearRampEvents(); err != nil {
+		return err
+	}
+
+	if err = driver.Jog(direction.signed(speed)); err != nil {
+		return err
+	}
+	if err = driver.waitForEvent(opts.Wait); !errors.Is(err, ErrStallGuardStop) {
+		return err
+	}
+
+	// Release the stop: with VMAX at 0 the motor stays put once sg_stop is off and the event is cleared
+	if err = driver.WriteRegister(VMAX, 0); err != nil {
+		return err
+	}
+	swMode.SgStop = false
+	if err = driver.WriteRegister(SW_MODE, swMode.Pack()); err != nil {
+		return err
+	}
+	if err = driver.ClearRampEvents(); err != nil {
+		return err
+	}
+	return driver.backOffAndZero(direction, speed, opts)
+}
+
+// waitForEvent polls RAMP_STAT until a stop or fault event ends the move, the timeout expires or the wait is canceled.
+// Standstill alone does not end the wait, as vzero can still be set right after the move was started.
+func (driver *Driver) waitForEvent(opts WaitOptions) error {
+	return driver.waitFor(func(*RAMP_STAT_Register) bool { return false }, opts)
+}
+
+// backOffAndZero moves away from the end of travel at the homing speed and defines the position as 0
+func (driver *Driver) backOffAndZero(direction HomingDirection, speed float32, opts HomingOptions) error {
+	if err := driver.backOff(direction, speed, opts); err != nil {
+		return err
+	}
+	return driver.setPosition(0)
+}
+
+// finishRun restores the registers and maximum speed changed by a homing or tuning routine.
+// If the routine ended in velocity mode, e.g. after a timeout, the motor is stopped first and the registers
+// are only restored at standstill, as chopper settings such as en_pwm_mode must not change during motion.
+func (driver *Driver) finishRun(saved []savedRegister, vmax float32) error {
+	driver.ramp.VMax = vmax
+	stopping := driver.rampMode != PositioningMode
+	if stopping {
+		vmax = 0
+	}
+	err := driver.WriteRegister(VMAX, driver.stepper.VelocityToVMAX(vmax))
+	if err == nil && stopping {
+		err = driver.waitVZero(stopTimeout)
+	}
+	if restoreErr := driver.restoreRegisters(saved); err == nil {
+		err = restoreErr
+	}
+	return err
+}
+
+// waitVZero polls RAMP_STAT until the ramp generator velocity is 0, ignoring stop events, which a routine
+// may have caused on purpose
+func (driver *Driver) waitVZero(timeout time.Duration) error {
+	rampStat := NewRAMP_STAT()
+	return poll(WaitOptions{Timeout: timeout}, func() (bool, error) {
+		value, err := driver.ReadRegister(RAMP_STAT)
+		if err != nil {
+			return false, err
+		}
+		rampStat.Unpack(value)
+		return rampStat.VZero, nil
+	})
+}
+
+// ReferenceSwitch selects the reference switch input used for homing
+type ReferenceSwitch uint8
+
+const (
+	LeftSwitch  ReferenceSwitch = iota // REFL, stops motion towards decreasing positions
+	RightSwitch                        // REFR, stops motion towards increasing positions
+)
+
+// SwitchHoming configures homing against a reference switch
+type SwitchHoming struct {
+	Switch       ReferenceSwitch // Switch used as home, after SwapLR is applied
+	ActiveLow    bool            // Switch input is active low (pol_stop_l/pol_stop_r)
+	SwapLR       bool            // Swap the REFL and REFR inputs
+	SoftStop     bool            // Decelerate with DMAX on the switch event instead of stopping hard
+	Speed        float32         // Search speed in units/s
+	SlowSpeed    float32         // Speed of a second, more precise approach in units/s (0 = single approach)
+	HomePosition float32         // Position in axis units assigned to the latched switch edge
+	Options      HomingOptions
+}
+
+// direction returns the homing direction towards the switch
+func (cfg *SwitchHoming) direction() HomingDirection {
+	if cfg.Switch == LeftSwitch {
+		return HomeNegative
+	}
+	return HomePositive
+}
+
+// HomeToSwitch homes the axis against a reference switch.
+// The motor runs towards the switch until its stop event, the exact trigger position is taken from XLATCH
+// (latched on the active edge) and the position is redefined so that the edge is at cfg.HomePosition,
+// regardless of how far the motor coasted. With a SlowSpeed the motor backs off and approaches the switch
+// a second time before latching. Finally it backs off the switch; SW_MODE is restored afterwards.
+func (driver *Driver) HomeToSwitch(cfg SwitchHoming) (err error) {
+	if cfg.Speed <= 0 || cfg.SlowSpeed < 0 {
+		return CustomError("homing speed must be greater than 0")
+	}
+	saved := driver.saveRegisters(SW_MODE)
+	vmax := driver.ramp.VMax
+	defer func() {
+		if restoreErr := driver.finishRun(saved, vmax); err == nil {
+			err = restoreErr
+		}
+	}()
+
+	swMode := NewSW_MODE()
+	swMode.Unpack(driver.shadowValue(SW_MODE))
+	swMode.SwapLR = cfg.SwapLR
+	swMode.EnSoftStop = cfg.SoftStop
+	if cfg.Switch == LeftSwitch {
+		swMode.StopLEnable = true
+		swMode.PolStopL = cfg.ActiveLow
+		swMode.LatchLActive = true
+	} else {
+		swMode.StopREnable = true
+		swMode.PolStopR = cfg.ActiveLow
+		swMode.LatchRActive = true
+	}
+	if err = driver.WriteRegister(SW_MODE, swMode.Pack()); err != nil {
+		return err
+	}
+
+	direction := cfg.direction()
+	if err = driver.leaveSwitch(cfg, direction); err != nil {
+		return err
+	}
+	if err = driver.approachSwitch(cfg, direction, cfg.Speed); err != nil {
+		return err
+	}
+	if cfg.SlowSpeed > 0 {
+		if err = driver.backOff(direction, cfg.Speed, cfg.Options); err != nil {
+			return err
+		}
+		if err = driver.approachSwitch(cfg, direction, cfg.SlowSpeed); err != nil {
+			return err
+		}
+	}
+
+	xlatch, err := driver.ReadRegister(XLATCH)
+	if err != nil {
+		return err
+	}
+	xactual, err := driver.readPosition()
+	if err != nil {
+		return err
+	}
+	home := driver.axis.ToMicrosteps(cfg.HomePosition)
+	if err = driver.setPosition(xactual - XACTUALToMicrosteps(xlatch) + home); err != nil {
+		return err
+	}
+	return driver.backOff(direction, cfg.Speed, cfg.Options)
+}
+
+// switchActive reports whether the homing switch input is active
+func (cfg *SwitchHoming) switchActive(rampStat *RAMP_STAT_Register) bool {
+	if cfg.Switch == LeftSwitch {
+		return rampStat.StatusStopL
+	}
+	return rampStat.StatusStopR
+}
+
+// leaveSwitch moves away from the switch if it is already active, so that the approach sees an active edge
+func (driver *Driver) leaveSwitch(cfg SwitchHoming, direction HomingDirection) error {
+	value, err := driver.ReadRegister(RAMP_STAT)
+	if err != nil {
+		return err
+	}
+	rampStat := NewRAMP_STAT()
+	rampStat.Unpack(value)
+	if !cfg.switchActive(rampStat) {
+		return nil
+	}
+	if err = driver.Jog(-direction.signed(cfg.Speed)); err != nil {
+		return err
+	}
+	err = driver.waitFor(func(r *RAMP_STAT_Register) bool { return !cfg.switchActive(r) }, cfg.Options.Wait)
+	if err != nil {
+		return err
+	}
+	if err = driver.WriteRegister(VMAX, 0); err != nil {
+		return err
+	}
+	return driver.WaitStandstill(cfg.Options.Wait)
+}
+
+// approachSwitch runs towards the switch until its stop event, with the position latch re-armed, and waits until
+// the motor came to rest, as a soft stop still decelerates after the event
+func (driver *Driver) approachSwitch(cfg SwitchHoming, direction HomingDirection, speed float32) error {
+	if err := driver.ClearRampEvents(); err != nil {
+		return err
+	}
+	if err := driver.Jog(direction.signed(speed)); err != nil {
+		return err
+	}
+	expected := ErrStopLeft
+	if cfg.Switch == RightSwitch {
+		expected = ErrStopRight
+	}
+	if err := driver.waitForEvent(cfg.Options.Wait); !errors.Is(err, expected) {
+		return err
+	}
+	if err := driver.WriteRegister(VMAX, 0); err != nil {
+		return err
+	}
+	return driver.waitVZero(stopTimeout)
+}
+
+// backOff moves the back-off distance away from the end of travel at the given speed
+func (driver *Driver) backOff(direction HomingDirection, speed float32, opts HomingOptions) error {
+	driver.ramp.VMax = driver.axis.ToSteps(speed)
+	if err := driver.MoveBy(driver.backoff(direction, opts)); err != nil {
+		return err
+	}
+	return driver.WaitPositionReached(opts.Wait)
+}
+
+// IndexHoming configures homing to the N (index) channel of an incremental encoder
+type IndexHoming struct {
+	Direction    HomingDirection // Search direction
+	Speed        float32         // Search speed in units/s, slow enough for the N pulse to be seen
+	ActiveHigh   bool            // N channel is active high (ENCMODE.PolN)
+	IgnoreAB     bool            // Accept the N pulse regardless of the A and B levels
+	PolA         bool            // A level required for an N event unless IgnoreAB is set
+	PolB         bool            // B level required for an N event unless IgnoreAB is set
+	HomePosition float32         // Position in axis units assigned to the index
+	Options      HomingOptions   // Backoff is not used
+}
+
+// HomeToIndex homes the axis to the encoder index pulse.
+// A one-shot N event latch is armed that also latches XACTUAL, the motor runs in velocity mode until the event
+// and stops. XACTUAL and X_ENC are then both redefined so that the positions latched at the index equal
+// cfg.HomePosition, which makes the reference independent of the stopping distance.
+// ENCMODE is restored afterwards; the encoder must have been configured with SetEncoder.
+func (driver *Driver) HomeToIndex(cfg IndexHoming) (err error) {
+	if cfg.Speed <= 0 {
+		return CustomError("homing speed must be greater than 0")
+	}
+	saved := driver.saveRegisters(ENCMODE)
+	vmax := driver.ramp.VMax
+	defer func() {
+		if restoreErr := driver.finishRun(saved, vmax); err == nil {
+			err = restoreErr
+		}
+	}()
+
+	encmode := NewENCMODE()
+	encmode.Unpack(driver.shadowValue(ENCMODE))
+	encmode.PolN = cfg.ActiveHigh
+	encmode.IgnoreAB = cfg.IgnoreAB
+	encmode.PolA = cfg.PolA
+	encmode.PolB = cfg.PolB
+	encmode.ClrCont = false
+	encmode.ClrEncX = false
+	encmode.ClrOnce = true
+	encmode.LatchXAct = true
+	if err = driver.WriteRegister(ENCMODE, encmode.Pack()); err != nil {
+		return err
+	}
+	if err = driver.clearNEvent(); err != nil {
+		return err
+	}
+
+	if err = driver.Jog(cfg.Direction.signed(cfg.Speed)); err != nil {
+		return err
+	}
+	var readErr error
+	encStatus := NewENC_STATUS()
+	nEvent := func(*RAMP_STAT_Register) bool {
+		var value uint32
+		if value, readErr = driver.ReadRegister(ENC_STATUS); readErr != nil {
+			return true
+		}
+		encStatus.Unpack(value)
+		return encStatus.NEvent
+	}
+	if err = driver.waitFor(nEvent, cfg.Options.Wait); err != nil {
+		return err
+	}
+	if readErr != nil {
+		return readErr
+	}
+	if _, err = driver.JogStop(cfg.Options.Wait); err != nil {
+		return err
+	}
+
+	xlatch, err := driver.ReadRegister(XLATCH)
+	if err != nil {
+		return err
+	}
+	encLatch, err := driver.ReadRegister(ENC_LATCH)
+	if err != nil {
+		return err
+	}
+	xactual, err := driver.readPosition()
+	if err != nil {
+		return err
+	}
+	xenc, err := driver.readEncoder()
+	if err != nil {
+		return err
+	}
+	home := driver.axis.ToMicrosteps(cfg.HomePosition)
+	if err = driver.WriteRegister(X_ENC, uint32(xenc-int32(encLatch)+home)); err != nil {
+		return err
+	}
+	if err = driver.setPosition(xactual - XACTUALToMicrosteps(xlatch) + home); err != nil {
+		return err
+	}
+	// The N event flag stays set until cleared
+	return driver.clearNEvent()
+}
+
+// clearNEvent clears ENC_STATUS.NEvent, which is cleared by writing 1, and leaves a pending deviation warning
+func (driver *Driver) clearNEvent() error {
+	encStatus := NewENC_STATUS()
+	encStatus.NEvent = true
+	return driver.WriteRegister(ENC_STATUS, encStatus.Pack())
+}
@@ -0,0 +1,58 @@
+package tmc5160
+
+// Jog runs the motor in velocity mode at a signed speed in units/s.
+// Speed and direction can be changed on the fly; the ramp generator accelerates and decelerates with AMAX,
+// passing through zero when the direction is reversed.
+func (driver *Driver) Jog(speed float32) error {
+	if err := driver.restoreRamp(); err != nil {
+		return err
+	}
+	amax := driver.stepper.AccelerationToAMAX(driver.ramp.AMax)
+	if amax == 0 {
+		return CustomError("acceleration not set")
+	}
+	if err := driver.WriteRegister(AMAX, amax); err != nil {
+		return err
+	}
+	mode := VelocityPositiveMode
+	if speed < 0 {
+		mode = VelocityNegativeMode
+		speed = -speed
+	}
+	return driver.jog(driver.axis.VelocityToVMAX(speed), mode)
+}
+
+// JogFixed is the integer variant of Jog for hot paths: speed is a signed velocity in motor millisteps/s
+// (1/1000 full steps per second) and AMAX is left as configured by SetAcceleration or SetRamp.
+func (driver *Driver) JogFixed(speed int32) error {
+	mode := VelocityPositiveMode
+	if speed < 0 {
+		mode = VelocityNegativeMode
+		speed = -speed
+	}
+	return driver.jog(driver.stepper.VelocityToVMAXFixed(uint32(speed)), mode)
+}
+
+// jog writes VMAX before RAMPMODE so that a direction change ramps towards the new speed
+func (driver *Driver) jog(vmax uint32, mode RampMode) error {
+	driver.positionKnown = false
+	if err := driver.WriteRegister(VMAX, vmax); err != nil {
+		return err
+	}
+	if driver.rampMode == mode {
+		return nil
+	}
+	return driver.setRampMode(mode)
+}
+
+// JogStop ramps down to zero velocity with the deceleration of Stop, waits for standstill and returns the
+// final position in axis units
+func (driver *Driver) JogStop(opts WaitOptions) (float32, error) {
+	if err := driver.Stop(); err != nil {
+		return 0, err
+	}
+	if err := driver.WaitStandstill(opts); err != nil {
+		return 0, err
+	}
+	return driver.Position()
+}
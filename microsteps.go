@@ -0,0 +1,142 @@
+package tmc5160
+
+// Registers counting in microsteps of the MRES resolution, rescaled by SetMicrosteps when they were written
+var microstepVelocityRegisters = []struct {
+	reg uint8
+	max uint32
+}{
+	{VSTART, maxVSTART}, {A_1, maxAccel}, {V_1, maxV1}, {AMAX, maxAccel}, {VMAX, maxVMAX},
+	{DMAX, maxAccel}, {D_1, maxAccel}, {VSTOP, maxVSTOP}, {VDCMIN, maxVMAX},
+}
+
+// microstepsToMres returns the CHOPCONF.Mres setting for a resolution of 1..256 microsteps per fullstep
+func microstepsToMres(microsteps uint16) (uint8, error) {
+	for mres := uint8(0); mres <= 8; mres++ {
+		if microsteps == 256>>mres {
+			return mres, nil
+		}
+	}
+	return 0, CustomError("microsteps must be a power of 2 from 1 to 256")
+}
+
+// Microsteps returns the microstep resolution of the Stepper
+func (driver *Driver) Microsteps() uint16 {
+	return uint16(driver.stepper.microsteps())
+}
+
+// SetMicrosteps changes the microstep resolution to n (1, 2, 4 .. 256) microsteps per fullstep at standstill.
+// CHOPCONF.Mres is written with interpolation to 256 microsteps, and Stepper.MSteps is updated so that the
+// conversions stay correct. XACTUAL, XTARGET, X_COMPARE, the encoder position and the ramp velocities and
+// accelerations count in microsteps, so they are rescaled to keep the physical position and speeds, as is the
+// position of a StepDir.
+// The TSTEP based thresholds (TPWMTHRS, TCOOLTHRS, THIGH) do not depend on the resolution.
+func (driver *Driver) SetMicrosteps(n uint16) error {
+	mres, err := microstepsToMres(n)
+	if err != nil {
+		return err
+	}
+	if driver.DirectMode() {
+		// XTARGET holds the XDIRECT coil currents, which must not be rescaled as a position
+		return CustomError("microstep resolution cannot change in direct mode")
+	}
+	vactual, err := driver.ReadRegister(VACTUAL)
+	if err != nil {
+		return err
+	}
+	if vactual != 0 {
+		return CustomError("motor must be at standstill to change the microstep resolution")
+	}
+	old := int64(driver.stepper.microsteps())
+	scale := func(value int32) int32 {
+		return int32(divRoundSigned(int64(value)*int64(n), old))
+	}
+
+	// Compute everything before writing, so that nothing changes if a value is out of range
+	xactual, err := driver.readPosition()
+	if err != nil {
+		return err
+	}
+	xtarget, err := driver.ReadRegister(XTARGET)
+	if err != nil {
+		return err
+	}
+	var writes []savedRegister
+	if value, ok := driver.shadow[X_COMPARE]; ok {
+		writes = append(writes, savedRegister{X_COMPARE, uint32(scale(int32(value)))})
+	}
+	if value, ok := driver.shadow[ENC_CONST]; ok {
+		encmode := NewENCMODE()
+		encmode.Unpack(driver.shadowValue(ENCMODE))
+		encConst, err := scaleEncoderConstant(value, encmode.EncSelDecimal, int64(n), old)
+		if err != nil {
+			return err
+		}
+		xenc, err := driver.readEncoder()
+		if err != nil {
+			return err
+		}
+		writes = append(writes, savedRegister{ENC_CONST, encConst}, savedRegister{X_ENC, uint32(scale(xenc))})
+	}
+	if value, ok := driver.shadow[ENC_DEVIATION]; ok {
+		writes = append(writes, savedRegister{ENC_DEVIATION, uint32(scale(int32(value)))})
+	}
+	scaled := make(map[uint8]uint32)
+	for _, r := range microstepVelocityRegisters {
+		if value, ok := driver.shadow[r.reg]; ok {
+			// A velocity or acceleration that was set must not round down to 0, e.g. D1 in positioning mode
+			scaled[r.reg] = constrain(uint32(scale(int32(value))), min(value, 1), r.max)
+		}
+	}
+	if vstop, ok := scaled[VSTOP]; ok {
+		if scaled[VSTOP] = stopVelocity(scaled[VSTART], vstop); scaled[VSTOP] > maxVSTOP {
+			return CustomError("VSTART too high to keep VSTOP above it")
+		}
+	}
+	for _, r := range microstepVelocityRegisters {
+		if value, ok := scaled[r.reg]; ok {
+			writes = append(writes, savedRegister{r.reg, value})
+		}
+	}
+
+	// Hold the ramp generator while the resolution and the positions change
+	mode := driver.rampMode
+	if err = driver.setRampMode(HoldMode); err != nil {
+		return err
+	}
+	chopconf := NewCHOPCONF()
+	chopconf.Unpack(driver.shadowValue(CHOPCONF))
+	chopconf.Mres = mres
+	chopconf.Intpol = true
+	if err = driver.WriteRegister(CHOPCONF, chopconf.Pack()); err != nil {
+		return err
+	}
+	driver.stepper.MSteps = n
+	writes = append(writes,
+		savedRegister{XACTUAL, MicrostepsToXACTUAL(scale(xactual))},
+		savedRegister{XTARGET, MicrostepsToXACTUAL(scale(XACTUALToMicrosteps(xtarget)))})
+	for _, w := range writes {
+		if err = driver.WriteRegister(w.reg, w.value); err != nil {
+			return err
+		}
+	}
+	driver.rememberPosition(scale(xactual)) // Read above at standstill, now in the new resolution
+	return driver.setRampMode(mode)
+}
+
+// scaleEncoderConstant multiplies an ENC_CONST value by num/den in its binary or decimal format
+func scaleEncoderConstant(value uint32, decimal bool, num, den int64) (uint32, error) {
+	if !decimal {
+		scaled := divRoundSigned(int64(int32(value))*num, den)
+		if scaled >= 1<<31 || scaled < -1<<31 {
+			return 0, CustomError("encoder factor out of range")
+		}
+		return uint32(scaled), nil
+	}
+	factor := int64(int16(value>>16))*10000 + int64(value&0xFFFF)
+	scaled := divRoundSigned(factor*num, den)
+	integer := floorDiv(scaled, 10000)
+	if integer >= 1<<15 || integer < -1<<15 {
+		return 0, CustomError("encoder factor out of range")
+	}
+	return uint32(integer)<<16 | uint32(scaled-integer*10000), nil
+}
@@ -0,0 +1,236 @@
+package tmc5160
+
+// Motion functions use the Driver's Axis: positions are in axis units, speeds in units/s and accelerations in units/s².
+
+// setRampMode writes RAMPMODE and remembers the mode
+func (driver *Driver) setRampMode(mode RampMode) error {
+	if err := driver.WriteRegister(RAMPMODE, uint32(mode)); err != nil {
+		return err
+	}
+	driver.rampMode = mode
+	return nil
+}
+
+// RampMode returns the ramp mode last written by the Driver
+func (driver *Driver) RampMode() RampMode {
+	return driver.rampMode
+}
+
+// readPosition reads XACTUAL in microsteps. It is remembered for the reset recovery only if the SPI status byte
+// shows the motor at standstill, as a position read during a move is stale by the time a reset is detected.
+func (driver *Driver) readPosition() (int32, error) {
+	xactual, err := driver.ReadRegister(XACTUAL)
+	if err != nil {
+		return 0, err
+	}
+	microsteps := XACTUALToMicrosteps(xactual)
+	if status, ok := driver.comm.(StatusComm); ok && status.Status(driver.address)&SPIStatusStandstill != 0 {
+		driver.rememberPosition(microsteps)
+	}
+	return microsteps, nil
+}
+
+// rememberPosition keeps a position read at rest for the reset recovery,
+// unless the SPI status byte shows that the chip was reset and XACTUAL is no longer valid
+func (driver *Driver) rememberPosition(microsteps int32) {
+	if status, ok := driver.comm.(StatusComm); ok && status.Status(driver.address)&SPIStatusResetFlag != 0 {
+		return
+	}
+	driver.position = microsteps
+	driver.positionKnown = true
+}
+
+// Position returns the actual position in axis units
+func (driver *Driver) Position() (float32, error) {
+	microsteps, err := driver.readPosition()
+	if err != nil {
+		return 0, err
+	}
+	return driver.axis.FromMicrosteps(microsteps), nil
+}
+
+// Velocity returns the actual signed velocity from the ramp generator in units/s
+func (driver *Driver) Velocity() (float32, error) {
+	vactual, err := driver.ReadRegister(VACTUAL)
+	if err != nil {
+		return 0, err
+	}
+	return driver.axis.VACTUALToVelocity(vactual), nil
+}
+
+// SetPosition redefines the actual position without moving the motor. The motor should be at standstill.
+func (driver *Driver) SetPosition(position float32) error {
+	return driver.setPosition(driver.axis.ToMicrosteps(position))
+}
+
+// setPosition writes XACTUAL and XTARGET in hold mode so that the ramp generator does not start a move
+func (driver *Driver) setPosition(microsteps int32) error {
+	mode := driver.rampMode
+	if err := driver.setRampMode(HoldMode); err != nil {
+		return err
+	}
+	if err := driver.WriteRegister(XACTUAL, MicrostepsToXACTUAL(microsteps)); err != nil {
+		return err
+	}
+	if err := driver.WriteRegister(XTARGET, MicrostepsToXACTUAL(microsteps)); err != nil {
+		return err
+	}
+	driver.position = microsteps
+	driver.positionKnown = true
+	return driver.setRampMode(mode)
+}
+
+// restoreRamp rewrites VSTART and AMAX after a HardStop replaced them
+func (driver *Driver) restoreRamp() error {
+	if !driver.rampDirty {
+		return nil
+	}
+	if err := driver.WriteRegister(VSTART, constrain(driver.stepper.VelocityToVMAX(driver.ramp.VStart), 0, maxVSTART)); err != nil {
+		return err
+	}
+	if err := driver.WriteRegister(AMAX, driver.stepper.AccelerationToAMAX(driver.ramp.AMax)); err != nil {
+		return err
+	}
+	driver.rampDirty = false
+	return nil
+}
+
+// enterPositioning switches to positioning mode without a jump.
+// XTARGET is set to XACTUAL before the switch so that the ramp generator does not head for a stale target,
+// and VMAX is restored in case a stop in velocity mode set it to 0.
+func (driver *Driver) enterPositioning() error {
+	driver.positionKnown = false
+	if err := driver.restoreRamp(); err != nil {
+		return err
+	}
+	if driver.rampMode == PositioningMode {
+		return nil
+	}
+	xactual, err := driver.ReadRegister(XACTUAL)
+	if err != nil {
+		return err
+	}
+	if err = driver.WriteRegister(XTARGET, xactual); err != nil {
+		return err
+	}
+	if err = driver.WriteRegister(VMAX, driver.stepper.VelocityToVMAX(driver.ramp.VMax)); err != nil {
+		return err
+	}
+	return driver.setRampMode(PositioningMode)
+}
+
+// MoveTo starts a move to an absolute position in axis units
+func (driver *Driver) MoveTo(position float32) error {
+	if err := driver.enterPositioning(); err != nil {
+		return err
+	}
+	return driver.WriteRegister(XTARGET, driver.axis.PositionToXACTUAL(position))
+}
+
+// MoveBy starts a move relative to the current target position in axis units.
+// After velocity mode or a stop, the move is relative to the actual position.
+func (driver *Driver) MoveBy(delta float32) error {
+	if err := driver.enterPositioning(); err != nil {
+		return err
+	}
+	xtarget, err := driver.ReadRegister(XTARGET)
+	if err != nil {
+		return err
+	}
+	target := XACTUALToMicrosteps(xtarget) + driver.axis.ToMicrosteps(delta)
+	return driver.WriteRegister(XTARGET, MicrostepsToXACTUAL(target))
+}
+
+// SetSpeed sets the maximum speed of moves in units/s.
+// Outside positioning mode the speed is only remembered, since writing VMAX would start the motor;
+// it is applied by the next MoveTo or MoveBy.
+func (driver *Driver) SetSpeed(speed float32) error {
+	if speed < 0 {
+		return CustomError("speed must not be negative")
+	}
+	if driver.rampMode != PositioningMode {
+		driver.ramp.VMax = driver.axis.ToSteps(speed)
+		return nil
+	}
+	return driver.SetMaxSpeed(driver.axis.ToSteps(speed))
+}
+
+// SetAcceleration sets acceleration and deceleration in units/s².
+// Without a V1 transition velocity the first phase is unused and A1/D1 follow AMAX/DMAX.
+func (driver *Driver) SetAcceleration(acceleration float32) error {
+	if acceleration <= 0 {
+		return CustomError("acceleration must be greater than 0")
+	}
+	ramp := driver.ramp
+	ramp.AMax = driver.axis.ToSteps(acceleration)
+	ramp.DMax = ramp.AMax
+	if ramp.V1 == 0 {
+		ramp.A1 = 0
+		ramp.D1 = 0
+	}
+	regs, err := driver.stepper.PlanRamp(ramp)
+	if err != nil {
+		return err
+	}
+	for _, w := range []struct {
+		reg   uint8
+		value uint32
+	}{{A_1, regs.A1}, {AMAX, regs.AMax}, {DMAX, regs.DMax}, {D_1, regs.D1}} {
+		if err = driver.WriteRegister(w.reg, w.value); err != nil {
+			return err
+		}
+	}
+	driver.ramp = ramp
+	return driver.restoreRamp()
+}
+
+// stopWith ramps down to zero velocity in velocity mode, keeping the current direction so no reversal is started
+func (driver *Driver) stopWith(amax uint32) error {
+	driver.positionKnown = false
+	vactual, err := driver.ReadRegister(VACTUAL)
+	if err != nil {
+		return err
+	}
+	mode := VelocityPositiveMode
+	if int32(vactual<<8) < 0 {
+		mode = VelocityNegativeMode
+	}
+	if err = driver.WriteRegister(AMAX, amax); err != nil {
+		return err
+	}
+	if err = driver.WriteRegister(VMAX, 0); err != nil {
+		return err
+	}
+	return driver.setRampMode(mode)
+}
+
+// Stop decelerates the motor to standstill using the configured deceleration (DMax, or AMax if it is not set).
+// The next MoveTo or MoveBy continues from wherever the motor came to rest.
+func (driver *Driver) Stop() error {
+	if err := driver.restoreRamp(); err != nil {
+		return err
+	}
+	deceleration := driver.ramp.DMax
+	if deceleration == 0 {
+		deceleration = driver.ramp.AMax
+	}
+	if err := driver.stopWith(driver.stepper.AccelerationToAMAX(deceleration)); err != nil {
+		return err
+	}
+	// Velocity mode decelerates with AMAX, which the next motion command sets back to the acceleration
+	driver.rampDirty = deceleration != driver.ramp.AMax
+	return nil
+}
+
+// HardStop stops the motor as fast as the ramp generator allows, using the maximum AMAX.
+// Steps may be lost at high speed. The configured acceleration is restored by the next motion command.
+func (driver *Driver) HardStop() error {
+	if err := driver.WriteRegister(VSTART, 0); err != nil {
+		return err
+	}
+	if err := driver.stopWith(maxAccel); err != nil {
+		return err
+	}
+	driver.rampDirty = true
+	return nil
+}
@@ -0,0 +1,149 @@
+package tmc5160
+
+// Power-on values of the microstep table registers
+var (
+	defaultMSLUT      = [8]uint32{0xAAAAB554, 0x4A9554AA, 0x24492929, 0x10104222, 0xFBFFFFFF, 0xB5BB777D, 0x49295556, 0x00404222}
+	defaultMSLUTSEL   = uint32(0xFFFF8056)
+	defaultMSLUTSTART = uint32(0x00F70000)
+)
+
+// MicrostepTable holds the motor current of a quarter sine wave for microstep table entries 0 (0°) to 256 (90°).
+// Entry 0 is START_SIN, entry 256 is START_SIN90 from which the driver runs the cosine wave backwards.
+// Consecutive entries may differ by -1 to 3, and the table can be split into at most four segments
+// in each of which only two neighbouring differences occur.
+type MicrostepTable [257]uint8
+
+// MicrostepTableRegisters holds a MicrostepTable encoded into the MSLUT0..7, MSLUTSEL and MSLUTSTART registers
+type MicrostepTableRegisters struct {
+	MSLUT      [8]uint32
+	MSLUTSEL   uint32
+	MSLUTSTART uint32
+}
+
+// DefaultMicrostepTable returns the driver's power-on table, a sine wave with an amplitude of 248
+func DefaultMicrostepTable() MicrostepTable {
+	regs := MicrostepTableRegisters{MSLUT: defaultMSLUT, MSLUTSEL: defaultMSLUTSEL, MSLUTSTART: defaultMSLUTSTART}
+	return regs.Decode()
+}
+
+// SineMicrostepTable computes a sine table with an amplitude of 248, within one step of DefaultMicrostepTable
+func SineMicrostepTable() MicrostepTable {
+	var table MicrostepTable
+	for i, value := range calculateSineWaveTable() {
+		table[i] = uint8(max(value, 0))
+	}
+	table[256] = 247
+	return table
+}
+
+// Encode converts the table into register values.
+// The differences between consecutive entries are greedily split into the longest segments possible;
+// an error is returned if a difference is out of range or more than four segments are needed.
+func (table *MicrostepTable) Encode() (MicrostepTableRegisters, error) {
+	var regs MicrostepTableRegisters
+	var widths [4]uint8
+	var starts [4]uint8 // starts[0] is always 0; X1..X3 = starts[1..3]
+	segment := 0
+	lo, hi := 3, -1
+	for i := 0; i < 256; i++ {
+		delta := int(table[i+1]) - int(table[i])
+		if delta < -1 || delta > 3 {
+			return regs, CustomError("microstep table step out of range (-1..3)")
+		}
+		if max(hi, delta)-min(lo, delta) > 1 {
+			if segment == 3 {
+				return regs, CustomError("microstep table needs more than four segments")
+			}
+			widths[segment] = segmentWidth(lo)
+			segment++
+			starts[segment] = uint8(i)
+			lo, hi = delta, delta
+		} else {
+			lo, hi = min(lo, delta), max(hi, delta)
+		}
+	}
+	widths[segment] = segmentWidth(lo)
+	// Unused segments start at 255 and repeat the last width, as entry 255 falls into segment 3
+	for s := segment + 1; s < 4; s++ {
+		starts[s] = 255
+		widths[s] = widths[segment]
+	}
+
+	for i, s := 0, 0; i < 256; i++ {
+		for s < 3 && i >= int(starts[s+1]) {
+			s++
+		}
+		bit := int(table[i+1]) - int(table[i]) - (int(widths[s]) - 1)
+		if bit == 1 {
+			regs.MSLUT[i/32] |= 1 << (i % 32)
+		}
+	}
+	mslutsel := NewMSLUTSEL()
+	mslutsel.W0, mslutsel.W1, mslutsel.W2, mslutsel.W3 = widths[0], widths[1], widths[2], widths[3]
+	mslutsel.X1, mslutsel.X2, mslutsel.X3 = starts[1], starts[2], starts[3]
+	regs.MSLUTSEL = mslutsel.Pack()
+	mslutstart := NewMSLUTSTART()
+	mslutstart.START_SIN = table[0]
+	mslutstart.START_SIN90 = table[256]
+	regs.MSLUTSTART = mslutstart.Pack()
+	return regs, nil
+}
+
+// segmentWidth returns the MSLUTSEL width whose two differences (W-1 and W) include the smallest difference lo
+func segmentWidth(lo int) uint8 {
+	return uint8(min(lo+1, 3))
+}
+
+// Decode converts register values back into a table
+func (regs *MicrostepTableRegisters) Decode() MicrostepTable {
+	var table MicrostepTable
+	mslutsel := NewMSLUTSEL()
+	mslutsel.Unpack(regs.MSLUTSEL)
+	mslutstart := NewMSLUTSTART()
+	mslutstart.Unpack(regs.MSLUTSTART)
+	value := int(mslutstart.START_SIN)
+	table[0] = uint8(value)
+	for i := 0; i < 256; i++ {
+		width := mslutsel.W0
+		switch {
+		case i >= int(mslutsel.X3):
+			width = mslutsel.W3
+		case i >= int(mslutsel.X2):
+			width = mslutsel.W2
+		case i >= int(mslutsel.X1):
+			width = mslutsel.W1
+		}
+		value += int(width) - 1 + int(regs.MSLUT[i/32]>>(i%32)&1)
+		table[i+1] = uint8(value)
+	}
+	return table
+}
+
+// SetMicrostepTable encodes the table and writes MSLUT0..7, MSLUTSEL and MSLUTSTART
+func (driver *Driver) SetMicrostepTable(table MicrostepTable) error {
+	regs, err := table.Encode()
+	if err != nil {
+		return err
+	}
+	for i, value := range regs.MSLUT {
+		if err = driver.WriteRegister(MSLUT0+uint8(i), value); err != nil {
+			return err
+		}
+	}
+	if err = driver.WriteRegister(MSLUTSEL, regs.MSLUTSEL); err != nil {
+		return err
+	}
+	return driver.WriteRegister(MSLUTSTART, regs.MSLUTSTART)
+}
+
+// MicrostepTable returns the table in use. The registers are write-only, so the table is decoded
+// from the values last written by the Driver, or the power-on defaults.
+func (driver *Driver) MicrostepTable() MicrostepTable {
+	var regs MicrostepTableRegisters
+	for i := range regs.MSLUT {
+		regs.MSLUT[i] = driver.shadowValueOr(MSLUT0+uint8(i), defaultMSLUT[i])
+	}
+	regs.MSLUTSEL = driver.shadowValueOr(MSLUTSEL, defaultMSLUTSEL)
+	regs.MSLUTSTART = driver.shadowValueOr(MSLUTSTART, defaultMSLUTSTART)
+	return regs.Decode()
+}
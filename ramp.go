@@ -0,0 +1,167 @@
+package tmc5160
+
+// RampProfile describes the six-point ramp of the motion controller.
+// Velocities are in full steps/s and accelerations in full steps/s².
+type RampProfile struct {
+	VStart float32 // Start velocity
+	A1     float32 // Acceleration between VStart and V1
+	V1     float32 // Transition velocity between the A1/D1 and AMax/DMax phases (0 = AMax/DMax only)
+	AMax   float32 // Acceleration between V1 and VMax
+	VMax   float32 // Target velocity
+	DMax   float32 // Deceleration between VMax and V1 (0 = same as AMax)
+	D1     float32 // Deceleration between V1 and VStop (0 = same as A1, or DMax without A1)
+	VStop  float32 // Stop velocity
+}
+
+// RampRegisters holds the register values computed from a RampProfile
+type RampRegisters struct {
+	VStart uint32
+	A1     uint32
+	V1     uint32
+	AMax   uint32
+	VMax   uint32
+	DMax   uint32
+	D1     uint32
+	VStop  uint32
+}
+
+// PlanRamp converts a RampProfile into register values.
+// It enforces the datasheet rules VSTOP > VSTART (minimum 10) and D1 != 0.
+func (stepper *Stepper) PlanRamp(profile RampProfile) (RampRegisters, error) {
+	if profile.VStart < 0 || profile.A1 < 0 || profile.V1 < 0 || profile.AMax < 0 ||
+		profile.VMax < 0 || profile.DMax < 0 || profile.D1 < 0 || profile.VStop < 0 {
+		return RampRegisters{}, CustomError("ramp parameters must not be negative")
+	}
+
+	vStart := stepper.velocityToRegister(profile.VStart)
+	v1 := stepper.velocityToRegister(profile.V1)
+	vMax := stepper.velocityToRegister(profile.VMax)
+	vStop := stepper.velocityToRegister(profile.VStop)
+	a1 := stepper.accelerationToRegister(profile.A1)
+	aMax := stepper.accelerationToRegister(profile.AMax)
+	dMax := stepper.accelerationToRegister(profile.DMax)
+	d1 := stepper.accelerationToRegister(profile.D1)
+
+	if vStart > maxVSTART || vStop > maxVSTOP || v1 > maxV1 || vMax > maxVMAX {
+		return RampRegisters{}, CustomError("ramp velocity out of range")
+	}
+	if a1 > maxAccel || aMax > maxAccel || dMax > maxAccel || d1 > maxAccel {
+		return RampRegisters{}, CustomError("ramp acceleration out of range")
+	}
+	if aMax == 0 {
+		return RampRegisters{}, CustomError("AMAX must not be 0")
+	}
+	if v1 != 0 && a1 == 0 {
+		return RampRegisters{}, CustomError("A1 must not be 0 when V1 is used")
+	}
+
+	regs := RampRegisters{
+		VStart: uint32(vStart),
+		A1:     uint32(a1),
+		V1:     uint32(v1),
+		AMax:   uint32(aMax),
+		VMax:   uint32(vMax),
+		DMax:   uint32(dMax),
+		D1:     uint32(d1),
+		VStop:  uint32(vStop),
+	}
+
+	// Symmetric deceleration unless specified otherwise
+	if regs.DMax == 0 {
+		regs.DMax = regs.AMax
+	}
+	// D1 must not be 0 in positioning mode, even if V1=0
+	if regs.D1 == 0 {
+		if regs.A1 != 0 {
+			regs.D1 = regs.A1
+		} else {
+			regs.D1 = regs.DMax
+		}
+	}
+	// VSTOP must be above VSTART
+	regs.VStop = stopVelocity(regs.VStart, regs.VStop)
+	if regs.VStop > maxVSTOP {
+		return RampRegisters{}, CustomError("VSTART too high to keep VSTOP above it")
+	}
+	return regs, nil
+}
+
+// stopVelocity raises a VSTOP register value so that it is above VSTART and not below the recommended minimum
+func stopVelocity(vStart, vStop uint32) uint32 {
+	if vStop <= vStart {
+		vStop = vStart + 1
+	}
+	if vStop < minVSTOP {
+		vStop = minVSTOP
+	}
+	return vStop
+}
+
+// SetRamp plans the ramp and writes it to the Driver.
+// VMAX is written last so that a ramp in velocity mode only starts once all other parameters are in place.
+func (driver *Driver) SetRamp(profile RampProfile) error {
+	regs, err := driver.stepper.PlanRamp(profile)
+	if err != nil {
+		return err
+	}
+	writes := []struct {
+		reg   uint8
+		value uint32
+	}{
+		{VSTART, regs.VStart},
+		{A_1, regs.A1},
+		{V_1, regs.V1},
+		{AMAX, regs.AMax},
+		{DMAX, regs.DMax},
+		{D_1, regs.D1},
+		{VSTOP, regs.VStop},
+		{VMAX, regs.VMax},
+	}
+	for _, w := range writes {
+		if err := driver.WriteRegister(w.reg, w.value); err != nil {
+			return err
+		}
+	}
+	driver.ramp = profile
+	return nil
+}
+
+// Ramp returns the last ramp profile written with SetRamp
+func (driver *Driver) Ramp() RampProfile {
+	return driver.ramp
+}
+
+// setRampSpeeds writes start, stop and transition speeds in full steps/s
+func (driver *Driver) setRampSpeeds(startSpeed float32, stopSpeed float32, transitionSpeed float32) error {
+	vStart := constrain(driver.stepper.VelocityToVMAX(startSpeed), 0, maxVSTART)
+	vStop := constrain(stopVelocity(vStart, driver.stepper.VelocityToVMAX(stopSpeed)), 0, maxVSTOP)
+	v1 := constrain(driver.stepper.VelocityToVMAX(transitionSpeed), 0, maxV1)
+	if err := driver.WriteRegister(VSTART, vStart); err != nil {
+		return err
+	}
+	if err := driver.WriteRegister(V_1, v1); err != nil {
+		return err
+	}
+	if err := driver.WriteRegister(VSTOP, vStop); err != nil {
+		return err
+	}
+	driver.ramp.VStart = startSpeed
+	driver.ramp.VStop = stopSpeed
+	driver.ramp.V1 = transitionSpeed
+	return nil
+}
+
+// SetMaxSpeed writes VMAX from a speed in full steps/s
+func (driver *Driver) SetMaxSpeed(speed float32) error {
+	if err := driver.WriteRegister(VMAX, driver.stepper.VelocityToVMAX(speed)); err != nil {
+		return err
+	}
+	driver.ramp.VMax = speed
+	return nil
+}
+
+// SetMaxSpeedFixed writes VMAX from a speed in millisteps/s without using floating point.
+// It is meant for hot paths and does not update the VMax reported by Ramp.
+func (driver *Driver) SetMaxSpeedFixed(speed uint32) error {
+	return driver.WriteRegister(VMAX, driver.stepper.VelocityToVMAXFixed(speed))
+}
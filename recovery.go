@@ -0,0 +1,267 @@
+package tmc5160
+
+import (
+	"machine"
+	"time"
+)
+
+// ErrPermanentFault is wrapped by the FaultError returned when fault recovery gives up
+const ErrPermanentFault = CustomError("permanent driver fault")
+
+// FaultError reports faults that could not be recovered. The power stage is left switched off.
+type FaultError struct {
+	Faults   HealthStatus // Faults active after the last attempt
+	Attempts int          // Recovery attempts made
+}
+
+func (e *FaultError) Error() string {
+	return ErrPermanentFault.Error()
+}
+
+// Unwrap allows errors.Is(err, ErrPermanentFault)
+func (e *FaultError) Unwrap() error {
+	return ErrPermanentFault
+}
+
+// FaultClass groups the fault conditions that are recovered with the same RecoveryPolicy
+type FaultClass uint8
+
+const (
+	FaultOvertemperature FaultClass = iota // Overtemperature
+	FaultShort                             // Short to ground or supply, or a driver error without a known cause
+	FaultUndervoltage                      // Charge pump undervoltage
+)
+
+// faultClass returns the class of the most severe fault in a status
+func faultClass(faults HealthStatus) FaultClass {
+	switch {
+	case faults.Has(Overtemperature):
+		return FaultOvertemperature
+	case faults.Has(ShortToGroundA), faults.Has(ShortToGroundB), faults.Has(ShortToSupplyA), faults.Has(ShortToSupplyB):
+		return FaultShort
+	case faults.Has(ChargePumpUndervoltage):
+		return FaultUndervoltage
+	}
+	return FaultShort
+}
+
+// RecoveryPolicy configures the recovery of one FaultClass
+type RecoveryPolicy struct {
+	MaxRetries int           // Attempts before the fault is permanent (0 = no recovery)
+	Delay      time.Duration // Time the power stage stays off before the first attempt, doubled for every further attempt
+	MaxDelay   time.Duration // Upper limit of the doubled delay (0 = no limit)
+}
+
+// delay returns the backoff before attempt (1 for the first attempt)
+func (policy RecoveryPolicy) delay(attempt int) time.Duration {
+	d := policy.Delay
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if policy.MaxDelay > 0 && d >= policy.MaxDelay {
+			return policy.MaxDelay
+		}
+	}
+	return d
+}
+
+// RecoveryAttempt reports the outcome of one recovery attempt
+type RecoveryAttempt struct {
+	Class   FaultClass
+	Attempt int           // Attempt number, starting at 1
+	Delay   time.Duration // Time the power stage was off
+	Faults  HealthStatus  // Faults still active after re-enabling, 0 if the attempt succeeded
+	Time    time.Time     // When the attempt finished
+}
+
+// FaultRecovery configures SuperviseFaults
+type FaultRecovery struct {
+	Overtemperature RecoveryPolicy
+	Short           RecoveryPolicy
+	Undervoltage    RecoveryPolicy
+	Report          func(RecoveryAttempt) // Optional, called after every attempt
+	Wait            WaitOptions           // Polling interval, timeout and cancellation of the supervision
+}
+
+// DefaultFaultRecovery returns a policy that lets an overheated driver cool down for at least 10s
+// and retries short circuits and undervoltage quickly, three times each
+func DefaultFaultRecovery() FaultRecovery {
+	return FaultRecovery{
+		Overtemperature: RecoveryPolicy{MaxRetries: 3, Delay: 10 * time.Second, MaxDelay: time.Minute},
+		Short:           RecoveryPolicy{MaxRetries: 3, Delay: 100 * time.Millisecond, MaxDelay: 2 * time.Second},
+		Undervoltage:    RecoveryPolicy{MaxRetries: 3, Delay: 100 * time.Millisecond, MaxDelay: 2 * time.Second},
+	}
+}
+
+// policy returns the RecoveryPolicy of a FaultClass
+func (cfg *FaultRecovery) policy(class FaultClass) RecoveryPolicy {
+	switch class {
+	case FaultOvertemperature:
+		return cfg.Overtemperature
+	case FaultUndervoltage:
+		return cfg.Undervoltage
+	}
+	return cfg.Short
+}
+
+// Registers of the power stage configuration written back by a recovery, CHOPCONF last as it re-enables the stage
+var powerStageRegisters = []uint8{DRV_CONF, GLOBAL_SCALER, IHOLD_IRUN, PWMCONF, CHOPCONF}
+
+// SuperviseFaults polls DRV_STATUS and GSTAT and recovers from faults that switched the power stage off.
+// For each attempt the stage is switched off (TOFF = 0, and ENN high if the Driver has an enable pin), left off
+// for the backoff delay of the fault's policy, GSTAT is cleared and the power stage configuration is written back
+// to re-enable it.
+// If faults remain after MaxRetries attempts, the stage stays off and a *FaultError is returned;
+// the Driver then reports the faults with PermanentFault until ClearPermanentFault switches the stage back on.
+// Motion is not restarted after a recovery. Resets are left to CheckReset and SuperviseReset.
+// ErrWaitTimeout or ErrWaitCanceled end the supervision. If cfg.Wait.Done is closed while a recovery has the
+// stage switched off, the stage stays off and the faults are reported by PermanentFault as if recovery gave up.
+func (driver *Driver) SuperviseFaults(cfg FaultRecovery) error {
+	return poll(cfg.Wait, func() (bool, error) {
+		return false, driver.CheckFaults(cfg)
+	})
+}
+
+// CheckFaults reads DRV_STATUS and GSTAT once and, if a fault switched the power stage off, recovers from it
+// like SuperviseFaults. Without a fault it returns after the register reads, but a recovery blocks for the
+// backoff delays of all its attempts, over a minute for overtemperature with DefaultFaultRecovery;
+// cfg.Wait.Done cancels it.
+func (driver *Driver) CheckFaults(cfg FaultRecovery) error {
+	if driver.fault != 0 {
+		return &FaultError{Faults: driver.fault}
+	}
+	status, err := driver.ReadHealth(false)
+	if err != nil {
+		return err
+	}
+	faults := recoverableFaults(status)
+	if faults == 0 {
+		return nil
+	}
+	interval := cfg.Wait.Interval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	return driver.recoverFaults(faults, cfg, interval)
+}
+
+// recoverFaults runs the attempts of the policy for the fault class until the faults are gone
+func (driver *Driver) recoverFaults(faults HealthStatus, cfg FaultRecovery, interval time.Duration) error {
+	class := faultClass(faults)
+	policy := cfg.policy(class)
+	var saved []savedRegister
+	for _, reg := range powerStageRegisters {
+		if value, ok := driver.shadow[reg]; ok {
+			saved = append(saved, savedRegister{reg: reg, value: value})
+		}
+	}
+	attempt := 0
+	for {
+		if err := driver.disablePowerStage(); err != nil {
+			return err
+		}
+		if attempt == policy.MaxRetries {
+			driver.fault = faults
+			driver.faultConfig = saved
+			return &FaultError{Faults: faults, Attempts: attempt}
+		}
+		attempt++
+		delay := policy.delay(attempt)
+		if err := sleep(delay, cfg.Wait.Done); err != nil {
+			// Canceled with the stage off: keep the configuration for ClearPermanentFault
+			driver.fault = faults
+			driver.faultConfig = saved
+			return err
+		}
+		var err error
+		if faults, err = driver.reenablePowerStage(saved, interval, cfg.Wait.Done); err != nil {
+			return err
+		}
+		if cfg.Report != nil {
+			cfg.Report(RecoveryAttempt{Class: class, Attempt: attempt, Delay: delay, Faults: faults, Time: time.Now()})
+		}
+		if faults == 0 {
+			return nil
+		}
+	}
+}
+
+// disablePowerStage switches the power stage off with TOFF = 0 and the enable pin, if there is one.
+// The shadow keeps the configured CHOPCONF, so that read-modify-writes of other chopper settings do not
+// carry TOFF = 0 over into the configuration.
+func (driver *Driver) disablePowerStage() error {
+	if driver.enablePin != machine.NoPin {
+		driver.enablePin.High()
+	}
+	configured, ok := driver.shadow[CHOPCONF]
+	chopconf := NewCHOPCONF()
+	chopconf.Unpack(configured)
+	chopconf.Toff = 0
+	if err := driver.WriteRegister(CHOPCONF, chopconf.Pack()); err != nil {
+		return err
+	}
+	if ok {
+		driver.shadow[CHOPCONF] = configured
+	} else {
+		delete(driver.shadow, CHOPCONF)
+	}
+	return nil
+}
+
+// reenablePowerStage clears GSTAT, writes the saved power stage configuration and returns the faults
+// present after one polling interval
+func (driver *Driver) reenablePowerStage(saved []savedRegister, interval time.Duration, done <-chan struct{}) (HealthStatus, error) {
+	if err := driver.enablePowerStage(saved); err != nil {
+		return 0, err
+	}
+	if err := sleep(interval, done); err != nil {
+		return 0, err
+	}
+	status, err := driver.ReadHealth(false)
+	if err != nil {
+		return 0, err
+	}
+	return recoverableFaults(status), nil
+}
+
+// enablePowerStage clears the GSTAT fault flags and writes the saved power stage configuration
+func (driver *Driver) enablePowerStage(saved []savedRegister) error {
+	gstat := NewGSTAT()
+	gstat.DrvErr = true
+	gstat.UvCp = true
+	if err := driver.WriteRegister(GSTAT, gstat.Pack()); err != nil {
+		return err
+	}
+	for i := range saved {
+		if err := driver.WriteRegister(saved[i].reg, saved[i].value); err != nil {
+			return err
+		}
+	}
+	if driver.enablePin != machine.NoPin {
+		driver.enablePin.Low()
+	}
+	return nil
+}
+
+// recoverableFaults returns the faults handled by SuperviseFaults, which leaves resets to the reset detection
+func recoverableFaults(status HealthStatus) HealthStatus {
+	return status.Faults() &^ (1 << ChipReset)
+}
+
+// PermanentFault returns the faults SuperviseFaults gave up on, 0 if there are none
+func (driver *Driver) PermanentFault() HealthStatus {
+	return driver.fault
+}
+
+// ClearPermanentFault clears the permanent fault state after the cause was fixed, e.g. a motor was reconnected,
+// and switches the power stage back on with the configuration it had before the fault
+func (driver *Driver) ClearPermanentFault() error {
+	if driver.fault == 0 {
+		return nil
+	}
+	if err := driver.enablePowerStage(driver.faultConfig); err != nil {
+		return err
+	}
+	driver.fault = 0
+	driver.faultConfig = nil
+	return nil
+}
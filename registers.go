@@ -980,7 +980,7 @@ func (d *DCCTRL_Register) Unpack(registerValue uint32) {
 // DRV_STATUS_Register struct to represent the DRV_STATUS register
 type DRV_STATUS_Register struct {
 	Register
-	SgResult   uint16 // stallGuard2 result or motor temperature estimation in standstill (9 bits)
+	SgResult   uint16 // stallGuard2 result or motor temperature estimation in standstill (10 bits)
 	S2vsa      bool   // Short to supply indicator phase A
 	S2vsb      bool   // Short to supply indicator phase B
 	Stealth    bool   // stealthChop indicator
@@ -1010,7 +1010,7 @@ func (d *DRV_STATUS_Register) Pack() uint32 {
 	var registerValue uint32
 
 	// Pack each field using bitwise operations
-	registerValue |= uint32(d.SgResult&0x1FF) << 0 // SgResult: 9 bits
+	registerValue |= uint32(d.SgResult&0x3FF) << 0 // SgResult: 10 bits
 	if d.S2vsa {
 		registerValue |= 1 << 12 // S2vsa: 1 bit
 	}
@@ -1055,7 +1055,7 @@ func (d *DRV_STATUS_Register) Pack() uint32 {
 // Unpack method for DRV_STATUS: overrides the base Unpack
 func (d *DRV_STATUS_Register) Unpack(registerValue uint32) {
 	// Unpack each field using bitwise operations
-	d.SgResult = uint16((registerValue >> 0) & 0x1FF) // Extract 9 bits for SgResult
+	d.SgResult = uint16((registerValue >> 0) & 0x3FF) // Extract 10 bits for SgResult
 	d.S2vsa = (registerValue & (1 << 12)) != 0        // Extract 1 bit for S2vsa
 	d.S2vsb = (registerValue & (1 << 13)) != 0        // Extract 1 bit for S2vsb
 	d.Stealth = (registerValue & (1 << 14)) != 0      // Extract 1 bit for Stealth
@@ -1743,10 +1743,10 @@ func (t *TCOOLTHRS_Register) Unpack(registerValue uint32) {
 	t.Value = registerValue & 0xFFFFF // Mask to 20 bits
 }
 
-// THIGH_Register struct for THIGH register (16 bits)
+// THIGH_Register struct for THIGH register (20 bits)
 type THIGH_Register struct {
 	Register
-	Value uint16 // 16-bit value
+	Value uint32 // 20-bit value (stored in a 32-bit field)
 }
 
 // NewTHIGH creates a new THIGH register instance
@@ -1758,14 +1758,14 @@ func NewTHIGH() *THIGH_Register {
 	}
 }
 
-// Pack method for THIGH: returns the 16-bit value
-func (t *THIGH_Register) Pack() uint16 {
-	return t.Value // 16 bits, no masking needed
+// Pack method for THIGH: packs the 20-bit value into a 32-bit value
+func (t *THIGH_Register) Pack() uint32 {
+	return t.Value & 0xFFFFF // Mask to 20 bits
 }
 
-// Unpack method for THIGH: unpacks the 16-bit value
-func (t *THIGH_Register) Unpack(registerValue uint16) {
-	t.Value = registerValue // Direct assignment since it's 16 bits
+// Unpack method for THIGH: unpacks the 20-bit value from a 32-bit value
+func (t *THIGH_Register) Unpack(registerValue uint32) {
+	t.Value = registerValue & 0xFFFFF // Mask to 20 bits
 }
 
 // DMAX_Register struct for DMAX register (16 bits)
@@ -1936,13 +1936,40 @@ func NewMSCURACT() *MSCURACT_Register {
 
 // Pack method for MSCURACT: packs the 9-bit signed values for CUR_B and CUR_A into a 32-bit value
 func (m *MSCURACT_Register) Pack() uint32 {
-	return uint32(m.CUR_A<<16 | m.CUR_B) // Combine CUR_A and CUR_B into a 32-bit value
+	return uint32(uint16(m.CUR_A)&0x1FF)<<16 | uint32(uint16(m.CUR_B)&0x1FF) // Combine CUR_A and CUR_B into a 32-bit value
 }
 
 // Unpack method for MSCURACT: unpacks the 32-bit value into CUR_B and CUR_A
 func (m *MSCURACT_Register) Unpack(registerValue uint32) {
-	m.CUR_B = int16(registerValue & 0x1FF)         // Mask to get the lower 9 bits for CUR_B
-	m.CUR_A = int16((registerValue >> 16) & 0x1FF) // Mask to get the next 9 bits for CUR_A
+	m.CUR_B = int16(registerValue<<7) >> 7       // Sign-extend the lower 9 bits for CUR_B
+	m.CUR_A = int16((registerValue>>16)<<7) >> 7 // Sign-extend the next 9 bits for CUR_A
+}
+
+// XDIRECT_Register struct for XDIRECT register (coil currents in direct mode)
+type XDIRECT_Register struct {
+	Register
+	CoilA int16 // 9-bit signed current for coil A (-255..255)
+	CoilB int16 // 9-bit signed current for coil B (-255..255)
+}
+
+// NewXDIRECT creates a new XDIRECT register instance
+func NewXDIRECT() *XDIRECT_Register {
+	return &XDIRECT_Register{
+		Register: Register{
+			RegisterAddr: XDIRECT,
+		},
+	}
+}
+
+// Pack method for XDIRECT: packs coil A (bits 8..0) and coil B (bits 24..16)
+func (x *XDIRECT_Register) Pack() uint32 {
+	return uint32(uint16(x.CoilB)&0x1FF)<<16 | uint32(uint16(x.CoilA)&0x1FF)
+}
+
+// Unpack method for XDIRECT: unpacks the signed coil currents
+func (x *XDIRECT_Register) Unpack(registerValue uint32) {
+	x.CoilA = int16(registerValue<<7) >> 7       // Sign-extend the lower 9 bits for coil A
+	x.CoilB = int16((registerValue>>16)<<7) >> 7 // Sign-extend the next 9 bits for coil B
 }
 
 // LOST_STEPS_Register struct for LOST_STEPS register (20 bits)
@@ -1973,9 +2000,9 @@ func (l *LOST_STEPS_Register) Unpack(registerValue uint32) {
 // MSLUTSEL_Register struct for MSLUTSEL register (32 bits)
 type MSLUTSEL_Register struct {
 	Register
-	X3 uint8 // 3-bit value for LUT segment 3 start
-	X2 uint8 // 3-bit value for LUT segment 2 start
-	X1 uint8 // 3-bit value for LUT segment 1 start
+	X3 uint8 // 8-bit value for LUT segment 3 start
+	X2 uint8 // 8-bit value for LUT segment 2 start
+	X1 uint8 // 8-bit value for LUT segment 1 start
 	W3 uint8 // 2-bit value for LUT width control W3
 	W2 uint8 // 2-bit value for LUT width control W2
 	W1 uint8 // 2-bit value for LUT width control W1
@@ -1993,18 +2020,18 @@ func NewMSLUTSEL() *MSLUTSEL_Register {
 
 // Pack method for MSLUTSEL: combines all the fields into a 32-bit value
 func (m *MSLUTSEL_Register) Pack() uint32 {
-	return uint32(m.X3<<27 | m.X2<<24 | m.X1<<21 | m.W3<<18 | m.W2<<16 | m.W1<<14 | m.W0<<12) // Combine fields into a 32-bit value
+	return uint32(m.X3)<<24 | uint32(m.X2)<<16 | uint32(m.X1)<<8 | uint32(m.W3&0x03)<<6 | uint32(m.W2&0x03)<<4 | uint32(m.W1&0x03)<<2 | uint32(m.W0&0x03) // Combine fields into a 32-bit value
 }
 
 // Unpack method for MSLUTSEL: unpacks the 32-bit value into individual fields
 func (m *MSLUTSEL_Register) Unpack(registerValue uint32) {
-	m.X3 = uint8((registerValue >> 27) & 0x07) // Extract the 3 bits for X3
-	m.X2 = uint8((registerValue >> 24) & 0x07) // Extract the 3 bits for X2
-	m.X1 = uint8((registerValue >> 21) & 0x07) // Extract the 3 bits for X1
-	m.W3 = uint8((registerValue >> 18) & 0x03) // Extract the 2 bits for W3
-	m.W2 = uint8((registerValue >> 16) & 0x03) // Extract the 2 bits for W2
-	m.W1 = uint8((registerValue >> 14) & 0x03) // Extract the 2 bits for W1
-	m.W0 = uint8((registerValue >> 12) & 0x03) // Extract the 2 bits for W0
+	m.X3 = uint8((registerValue >> 24) & 0xFF) // Extract the 8 bits for X3
+	m.X2 = uint8((registerValue >> 16) & 0xFF) // Extract the 8 bits for X2
+	m.X1 = uint8((registerValue >> 8) & 0xFF)  // Extract the 8 bits for X1
+	m.W3 = uint8((registerValue >> 6) & 0x03)  // Extract the 2 bits for W3
+	m.W2 = uint8((registerValue >> 4) & 0x03)  // Extract the 2 bits for W2
+	m.W1 = uint8((registerValue >> 2) & 0x03)  // Extract the 2 bits for W1
+	m.W0 = uint8(registerValue & 0x03)         // Extract the 2 bits for W0
 }
 
 // MSLUT_Register struct for MSLUT register (32 bits)
@@ -2032,11 +2059,11 @@ func (m *MSLUT_Register) Unpack(registerValue uint32) {
 	m.Value = registerValue // Direct assignment since it's 32 bits
 }
 
-// MSLUTSTART_Register struct for MSLUTSTART register (16 bits)
+// MSLUTSTART_Register struct for MSLUTSTART register (24 bits)
 type MSLUTSTART_Register struct {
 	Register
-	START_SIN   int8 // 8-bit signed value for the absolute current at microstep entry 0
-	START_SIN90 int8 // 8-bit signed value for the absolute current at microstep entry 256
+	START_SIN   uint8 // 8-bit value for the absolute current at microstep entry 0
+	START_SIN90 uint8 // 8-bit value for the absolute current at microstep entry 256
 }
 
 // NewMSLUTSTART creates a new MSLUTSTART register instance
@@ -2048,15 +2075,15 @@ func NewMSLUTSTART() *MSLUTSTART_Register {
 	}
 }
 
-// Pack method for MSLUTSTART: combines START_SIN and START_SIN90 into a 16-bit value
-func (m *MSLUTSTART_Register) Pack() uint16 {
-	return uint16(m.START_SIN) | (uint16(m.START_SIN90) << 8) // Combine the 8-bit values into a 16-bit value
+// Pack method for MSLUTSTART: combines START_SIN (bits 7..0) and START_SIN90 (bits 23..16)
+func (m *MSLUTSTART_Register) Pack() uint32 {
+	return uint32(m.START_SIN) | uint32(m.START_SIN90)<<16
 }
 
-// Unpack method for MSLUTSTART: unpacks the 16-bit value into START_SIN and START_SIN90
-func (m *MSLUTSTART_Register) Unpack(registerValue uint16) {
-	m.START_SIN = int8(registerValue & 0xFF)          // Extract the lower 8 bits for START_SIN
-	m.START_SIN90 = int8((registerValue >> 8) & 0xFF) // Extract the upper 8 bits for START_SIN90
+// Unpack method for MSLUTSTART: unpacks START_SIN and START_SIN90
+func (m *MSLUTSTART_Register) Unpack(registerValue uint32) {
+	m.START_SIN = uint8(registerValue & 0xFF)           // Extract the lower 8 bits for START_SIN
+	m.START_SIN90 = uint8((registerValue >> 16) & 0xFF) // Extract bits 23..16 for START_SIN90
 }
 
 // Function to calculate the sine wave values for the microstep table
@@ -0,0 +1,153 @@
+package tmc5160
+
+import (
+	"slices"
+	"time"
+)
+
+// Registers that are not replayed from the shadow: status flags that are cleared by writing 1, OTP programming
+// and the clock trim reloaded from OTP, and the motion registers that RestoreConfiguration writes in a safe order
+var replaySkip = map[uint8]bool{
+	GSTAT: true, OTP_PROG: true, FACTORY_CONF: true, RAMP_STAT: true, ENC_STATUS: true,
+	RAMPMODE: true, XACTUAL: true, VMAX: true, XTARGET: true, X_ENC: true,
+}
+
+// ResetEvent reports a chip reset detected and repaired by the Driver
+type ResetEvent struct {
+	Time             time.Time // When the reset was detected
+	Position         float32   // Position in axis units written back to XACTUAL
+	PositionRestored bool      // XACTUAL was restored from a position read at rest after the last motion command
+	Err              error     // Error while restoring the configuration, nil if it was restored
+}
+
+// ResetSupervision configures SuperviseReset
+type ResetSupervision struct {
+	Wait WaitOptions // Polling interval, timeout and cancellation of the supervision
+}
+
+// OnReset registers fn to be called after the Driver detected a reset and restored its configuration,
+// e.g. to restart a move. It is called from the goroutine that detected the reset.
+func (driver *Driver) OnReset(fn func(ResetEvent)) {
+	driver.onReset = fn
+}
+
+// ResetDetected reports the GSTAT reset flag, taken from the SPI status byte when the interface provides it
+func (driver *Driver) ResetDetected() (bool, error) {
+	_, reset, err := driver.globalStatus()
+	return reset, err
+}
+
+// CheckReset restores the configuration if the chip was reset since the last check, e.g. after a brown-out.
+// It returns true if a reset was handled.
+func (driver *Driver) CheckReset() (bool, error) {
+	reset, err := driver.ResetDetected()
+	if err != nil || !reset {
+		return false, err
+	}
+	return true, driver.handleReset()
+}
+
+// SuperviseReset polls for chip resets and restores the configuration after each one.
+// ErrWaitTimeout or ErrWaitCanceled end the supervision.
+func (driver *Driver) SuperviseReset(cfg ResetSupervision) error {
+	return poll(cfg.Wait, func() (bool, error) {
+		_, err := driver.CheckReset()
+		return false, err
+	})
+}
+
+// handleReset restores the configuration and notifies the application
+func (driver *Driver) handleReset() error {
+	event := driver.RestoreConfiguration()
+	if driver.onReset != nil {
+		driver.onReset(event)
+	}
+	return event.Err
+}
+
+// RestoreConfiguration writes the last written value of every configuration register back to the Driver and
+// clears the GSTAT reset flag. The ramp generator is held while the registers are replayed, the power stage
+// configuration last so that CHOPCONF switches the stage on once everything else is in place. XACTUAL is set to
+// the position read at rest after the last motion command (and the encoder position with it), e.g. by a wait for
+// the end of a move, or by Position when the SPI status byte shows standstill, and the ramp mode is restored.
+// Positions read during a move or before a motion command are stale, so XACTUAL then stays at 0 and
+// PositionRestored is false.
+// A move in positioning mode ends at that position and a jog in velocity mode is stopped, so that the motor
+// does not start by itself; restart the motion from the OnReset callback if needed.
+func (driver *Driver) RestoreConfiguration() ResetEvent {
+	event := ResetEvent{Time: time.Now()}
+	event.Err = driver.restoreConfiguration(&event)
+	return event
+}
+
+func (driver *Driver) restoreConfiguration(event *ResetEvent) error {
+	gstat := NewGSTAT()
+	gstat.Reset = true
+	if err := driver.WriteRegister(GSTAT, gstat.Pack()); err != nil {
+		return err
+	}
+	mode := driver.rampMode
+	if err := driver.setRampMode(HoldMode); err != nil {
+		return err
+	}
+	for reg := GCONF; reg <= LOST_STEPS; reg++ {
+		value, ok := driver.shadow[reg]
+		if !ok || replaySkip[reg] || slices.Contains(powerStageRegisters, reg) {
+			continue
+		}
+		if err := driver.WriteRegister(reg, value); err != nil {
+			return err
+		}
+	}
+	// The power stage after the rest of the configuration, CHOPCONF last as its TOFF enables the stage
+	for _, reg := range powerStageRegisters {
+		value, ok := driver.shadow[reg]
+		if !ok {
+			continue
+		}
+		var err error
+		if reg == CHOPCONF && driver.fault != 0 {
+			err = driver.disablePowerStage() // Stays off until ClearPermanentFault
+		} else {
+			err = driver.WriteRegister(reg, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Without a known position XACTUAL stays at its reset value 0, and XTARGET follows it
+	var position int32
+	if driver.positionKnown {
+		position = driver.position
+		xactual := MicrostepsToXACTUAL(position)
+		if err := driver.WriteRegister(XACTUAL, xactual); err != nil {
+			return err
+		}
+		if _, ok := driver.shadow[ENC_CONST]; ok {
+			if err := driver.WriteRegister(X_ENC, xactual); err != nil {
+				return err
+			}
+		}
+		event.Position = driver.axis.FromMicrosteps(position)
+		event.PositionRestored = true
+	}
+	// XDIRECT shares the address of XTARGET, the shadow holds the coil currents in direct mode
+	if value, ok := driver.shadow[XDIRECT]; ok && driver.DirectMode() {
+		if err := driver.WriteRegister(XDIRECT, value); err != nil {
+			return err
+		}
+	} else if err := driver.WriteRegister(XTARGET, MicrostepsToXACTUAL(position)); err != nil {
+		return err
+	}
+
+	if vmax, ok := driver.shadow[VMAX]; ok {
+		if mode != PositioningMode {
+			vmax = 0
+		}
+		if err := driver.WriteRegister(VMAX, vmax); err != nil {
+			return err
+		}
+	}
+	return driver.setRampMode(mode)
+}
@@ -0,0 +1,41 @@
+package tmc5160
+
+// savedRegister is a register value captured from the shadow copy
+type savedRegister struct {
+	reg   uint8
+	value uint32
+}
+
+// shadowValue returns the last value written to a register, or 0 (the reset default of most registers) if it was never written
+func (driver *Driver) shadowValue(reg uint8) uint32 {
+	return driver.shadow[reg]
+}
+
+// shadowValueOr returns the last value written to a register, or reset if it was never written
+func (driver *Driver) shadowValueOr(reg uint8, reset uint32) uint32 {
+	if value, ok := driver.shadow[reg]; ok {
+		return value
+	}
+	return reset
+}
+
+// saveRegisters captures the shadow values of registers that a procedure is going to change
+func (driver *Driver) saveRegisters(regs ...uint8) []savedRegister {
+	saved := make([]savedRegister, len(regs))
+	for i, reg := range regs {
+		saved[i] = savedRegister{reg: reg, value: driver.shadowValue(reg)}
+	}
+	return saved
+}
+
+// restoreRegisters writes back registers captured by saveRegisters, in reverse order.
+// All registers are written even if one fails; the first error is returned.
+func (driver *Driver) restoreRegisters(saved []savedRegister) error {
+	var firstErr error
+	for i := len(saved) - 1; i >= 0; i-- {
+		if err := driver.WriteRegister(saved[i].reg, saved[i].value); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,161 @@
+package tmc5160
+
+import (
+	"time"
+)
+
+// Defaults used by TuneStallGuard
+const (
+	DefaultStallGuardSamples = 16
+	DefaultStallGuardSettle  = 100 * time.Millisecond
+)
+
+// ErrNoStallGuardThreshold is returned by TuneStallGuard when no swept SGT kept SG_RESULT above the margin
+const ErrNoStallGuardThreshold = CustomError("no stall-free SGT found")
+
+// StallGuardTuning configures TuneStallGuard
+type StallGuardTuning struct {
+	Speed    float32       // Signed tuning velocity in units/s, ideally the speed used for sensorless homing
+	SgtRange bool          // Sweep MinSgt..MaxSgt only (false = the full range -64..63)
+	MinSgt   int8          // Lowest SGT swept if SgtRange is set
+	MaxSgt   int8          // Highest SGT swept if SgtRange is set
+	Margin   uint16        // SG_RESULT that every sample must exceed at the recommended SGT
+	Samples  int           // SG_RESULT samples per SGT (0 = DefaultStallGuardSamples)
+	Settle   time.Duration // Delay after changing SGT before sampling (0 = DefaultStallGuardSettle)
+	Interval time.Duration // Delay between samples (0 = DefaultPollInterval)
+	Wait     WaitOptions   // Polling, timeout and cancellation while accelerating to the tuning velocity
+}
+
+// StallGuardSample holds the SG_RESULT statistics measured at one SGT
+type StallGuardSample struct {
+	Sgt  int8
+	Mean float32
+	Min  uint16
+	Max  uint16
+}
+
+// StallGuardResult is the outcome of TuneStallGuard
+type StallGuardResult struct {
+	Sgt     int8               // Recommended SGT
+	Samples []StallGuardSample // Statistics of every swept SGT, in ascending SGT order
+}
+
+// TuneStallGuard finds a stallGuard2 threshold for the current motor, load and speed.
+// The motor runs in velocity mode and spreadCycle at cfg.Speed while every SGT of the range is sampled in
+// ascending order. SG_RESULT rises with SGT; the recommended SGT is the most sensitive (lowest) one whose
+// samples all stay above cfg.Margin, so that the load seen during tuning gives SG_RESULT close to zero without
+// stalling. GCONF, COOLCONF and SW_MODE are restored and the motor is stopped afterwards.
+// The load should be representative of normal operation; an acceleration must have been set.
+func (driver *Driver) TuneStallGuard(cfg StallGuardTuning) (result StallGuardResult, err error) {
+	if cfg.Speed == 0 {
+		return result, CustomError("tuning speed must not be 0")
+	}
+	minSgt, maxSgt := int8(-64), int8(63)
+	if cfg.SgtRange {
+		minSgt, maxSgt = cfg.MinSgt, cfg.MaxSgt
+	}
+	if minSgt < -64 || maxSgt > 63 || minSgt > maxSgt {
+		return result, CustomError("sgt out of range (-64..63)")
+	}
+	samples := cfg.Samples
+	if samples <= 0 {
+		samples = DefaultStallGuardSamples
+	}
+	settle := cfg.Settle
+	if settle <= 0 {
+		settle = DefaultStallGuardSettle
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	saved := driver.saveRegisters(GCONF, COOLCONF, SW_MODE)
+	vmax := driver.ramp.VMax
+	defer func() {
+		if restoreErr := driver.finishRun(saved, vmax); err == nil {
+			err = restoreErr
+		}
+	}()
+
+	// stallGuard2 only works in spreadCycle, and coolStep would change the current while measuring
+	gconf := NewGCONF()
+	gconf.Unpack(driver.shadowValue(GCONF))
+	gconf.EnPwmMode = false
+	if err = driver.WriteRegister(GCONF, gconf.Pack()); err != nil {
+		return result, err
+	}
+	swMode := NewSW_MODE()
+	swMode.Unpack(driver.shadowValue(SW_MODE))
+	swMode.SgStop = false
+	if err = driver.WriteRegister(SW_MODE, swMode.Pack()); err != nil {
+		return result, err
+	}
+	coolconf := NewCOOLCONF()
+	coolconf.Unpack(driver.shadowValue(COOLCONF))
+	coolconf.Semin = 0
+
+	if err = driver.Jog(cfg.Speed); err != nil {
+		return result, err
+	}
+	if err = driver.WaitVelocityReached(cfg.Wait); err != nil {
+		return result, err
+	}
+
+	for sgt := int(minSgt); sgt <= int(maxSgt); sgt++ {
+		coolconf.Sgt = uint8(sgt) & 0x7F
+		if err = driver.WriteRegister(COOLCONF, coolconf.Pack()); err != nil {
+			return result, err
+		}
+		if err = sleep(settle, cfg.Wait.Done); err != nil {
+			return result, err
+		}
+		sample, err := driver.sampleStallGuard(int8(sgt), samples, interval, cfg.Wait.Done)
+		if err != nil {
+			return result, err
+		}
+		result.Samples = append(result.Samples, sample)
+	}
+	for _, sample := range result.Samples {
+		if sample.Min > cfg.Margin {
+			result.Sgt = sample.Sgt
+			return result, nil
+		}
+	}
+	result.Sgt = maxSgt
+	return result, ErrNoStallGuardThreshold
+}
+
+// sampleStallGuard reads SG_RESULT n times and returns its statistics
+func (driver *Driver) sampleStallGuard(sgt int8, n int, interval time.Duration, done <-chan struct{}) (StallGuardSample, error) {
+	sample := StallGuardSample{Sgt: sgt, Min: 0x3FF}
+	drvStatus := NewDRV_STATUS()
+	var sum uint32
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			if err := sleep(interval, done); err != nil {
+				return sample, err
+			}
+		}
+		value, err := driver.ReadRegister(DRV_STATUS)
+		if err != nil {
+			return sample, err
+		}
+		drvStatus.Unpack(value)
+		sum += uint32(drvStatus.SgResult)
+		sample.Min = min(sample.Min, drvStatus.SgResult)
+		sample.Max = max(sample.Max, drvStatus.SgResult)
+	}
+	sample.Mean = float32(sum) / float32(n)
+	return sample, nil
+}
+
+// sleep waits for d, returning ErrWaitCanceled if done is closed first
+func sleep(d time.Duration, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return ErrWaitCanceled
+	case <-time.After(d):
+		return nil
+	}
+}
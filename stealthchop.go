@@ -0,0 +1,141 @@
+package tmc5160
+
+import (
+	"time"
+)
+
+// Defaults used by TuneStealthChop
+const (
+	DefaultStealthChopStandstill = 150 * time.Millisecond // AT#1 needs at least 130 ms at run current
+	DefaultStealthChopRPM        = 120                    // AT#2 motor speed, the datasheet recommends 60 to 300 RPM
+	DefaultStealthChopTolerance  = 4                      // |PWM_SCALE_AUTO| regarded as converged
+)
+
+// StealthChopTuning configures TuneStealthChop
+type StealthChopTuning struct {
+	Speed      float32       // Signed AT#2 velocity in units/s (0 = DefaultStealthChopRPM motor revolutions per minute)
+	Standstill time.Duration // AT#1 time at standstill with run current (0 = DefaultStealthChopStandstill)
+	Tolerance  uint16        // |PWM_SCALE_AUTO| regarded as converged (0 = DefaultStealthChopTolerance)
+	Wait       WaitOptions   // Polling, timeout and cancellation of AT#2
+}
+
+// PwmTuning holds the stealthChop amplitude regulation values learned by TuneStealthChop
+type PwmTuning struct {
+	PwmOfs  uint8 // PWM_OFS_AUTO
+	PwmGrad uint8 // PWM_GRAD_AUTO
+}
+
+// Apply stores the learned values as the initial PWM_OFS and PWM_GRAD written by Begin
+func (tuning PwmTuning) Apply(params *MotorParameters) {
+	params.pwmOfsInitial = uint16(tuning.PwmOfs)
+	params.pwmGradInitial = uint16(tuning.PwmGrad)
+}
+
+// TuneStealthChop runs the stealthChop automatic tuning phases from the datasheet.
+// AT#1 determines PWM_OFS at standstill with IHOLD raised to IRUN, AT#2 determines PWM_GRAD while the motor
+// runs in velocity mode until PWM_SCALE_AUTO has settled near 0 with an unchanged PWM_AUTO.
+// The learned values are returned for persisting with PwmTuning.Apply, and also become the PWMCONF start values.
+// GCONF, TPWMTHRS, PWMCONF and IHOLD_IRUN are restored and the motor is stopped afterwards.
+// The motor must be at standstill and an acceleration must have been set.
+func (driver *Driver) TuneStealthChop(cfg StealthChopTuning) (tuning PwmTuning, err error) {
+	speed := cfg.Speed
+	if speed == 0 {
+		angle := driver.stepper.Angle
+		if angle == 0 {
+			angle = DefaultAngle
+		}
+		speed = driver.axis.FromSteps(DefaultStealthChopRPM * 360 / angle / 60)
+	}
+	standstill := cfg.Standstill
+	if standstill <= 0 {
+		standstill = DefaultStealthChopStandstill
+	}
+	tolerance := int16(cfg.Tolerance)
+	if tolerance == 0 {
+		tolerance = DefaultStealthChopTolerance
+	}
+
+	saved := driver.saveRegisters(GCONF, TPWMTHRS, PWMCONF, IHOLD_IRUN)
+	vmax := driver.ramp.VMax
+	defer func() {
+		if restoreErr := driver.finishRun(saved, vmax); err == nil {
+			err = restoreErr
+		}
+	}()
+
+	gconf := NewGCONF()
+	gconf.Unpack(driver.shadowValue(GCONF))
+	gconf.EnPwmMode = true
+	if err = driver.WriteRegister(GCONF, gconf.Pack()); err != nil {
+		return tuning, err
+	}
+	// stealthChop at any velocity
+	if err = driver.WriteRegister(TPWMTHRS, 0); err != nil {
+		return tuning, err
+	}
+	pwmconf := NewPWMCONF()
+	pwmconf.Unpack(driver.shadowValue(PWMCONF))
+	pwmconf.PwmAutoscale = true
+	pwmconf.PwmAutograd = true
+	if err = driver.WriteRegister(PWMCONF, pwmconf.Pack()); err != nil {
+		return tuning, err
+	}
+	iholdrun := NewIHOLD_IRUN()
+	iholdrun.Unpack(driver.shadowValue(IHOLD_IRUN))
+	iholdrun.Ihold = iholdrun.Irun
+	if err = driver.WriteRegister(IHOLD_IRUN, iholdrun.Pack()); err != nil {
+		return tuning, err
+	}
+
+	// AT#1
+	if err = driver.WaitStandstill(cfg.Wait); err != nil {
+		return tuning, err
+	}
+	if err = sleep(standstill, cfg.Wait.Done); err != nil {
+		return tuning, err
+	}
+
+	// AT#2
+	if err = driver.Jog(speed); err != nil {
+		return tuning, err
+	}
+	if err = driver.WaitVelocityReached(cfg.Wait); err != nil {
+		return tuning, err
+	}
+	var last uint32
+	var readErr error
+	pwmScale := NewPWM_SCALE()
+	converged := func(*RAMP_STAT_Register) bool {
+		var value, pwmAuto uint32
+		if value, readErr = driver.ReadRegister(PWM_SCALE); readErr != nil {
+			return true
+		}
+		if pwmAuto, readErr = driver.ReadRegister(PWM_AUTO); readErr != nil {
+			return true
+		}
+		pwmScale.Unpack(value)
+		scaleAuto := int16(pwmScale.PwmScaleAuto<<7) >> 7 // 9 bit signed
+		stable := scaleAuto >= -tolerance && scaleAuto <= tolerance && pwmAuto == last
+		last = pwmAuto
+		return stable
+	}
+	if err = driver.waitFor(converged, cfg.Wait); err != nil {
+		return tuning, err
+	}
+	if readErr != nil {
+		return tuning, readErr
+	}
+
+	pwmAuto := NewPWM_AUTO()
+	pwmAuto.Unpack(last)
+	tuning = PwmTuning{PwmOfs: pwmAuto.PwmOfsAuto, PwmGrad: pwmAuto.PwmGradAuto}
+	for i := range saved {
+		if saved[i].reg == PWMCONF {
+			pwmconf.Unpack(saved[i].value)
+			pwmconf.PwmOfs = tuning.PwmOfs
+			pwmconf.PwmGrad = tuning.PwmGrad
+			saved[i].value = pwmconf.Pack()
+		}
+	}
+	return tuning, nil
+}
@@ -0,0 +1,212 @@
+package tmc5160
+
+import (
+	"machine"
+	"time"
+
+	"github.com/orsinium-labs/tinymath"
+)
+
+// Minimum STEP high and low time and DIR setup time, well above the datasheet minimum of about 100ns
+const stepPulseWidth = time.Microsecond
+
+// StepDir moves a TMC5160 wired with SD_MODE=1, where the internal ramp generator is bypassed and every
+// microstep is a pulse on the STEP input generated by the MCU. Currents, chopper and stealthChop are still
+// configured over SPI through the Driver, and positions, speeds and accelerations are in the units of the
+// Driver's axis, just like the ramp generator functions.
+type StepDir struct {
+	driver       *Driver
+	step         machine.Pin
+	dir          machine.Pin
+	dedge        bool    // Step on both edges of STEP (CHOPCONF.Dedge)
+	invertDir    bool    // DIR low moves in the positive direction
+	position     int32   // Microsteps at the resolution in microsteps
+	microsteps   uint16  // Resolution position counts in, follows SetMicrosteps
+	speed        float32 // units/s
+	acceleration float32 // units/s²
+}
+
+// NewStepDir creates a StepDir generating pulses on the step and dir pins for the Driver's Stepper and axis.
+// With dedge every edge of STEP is a step, halving the pulse rate the MCU has to produce.
+func (driver *Driver) NewStepDir(step, dir machine.Pin, dedge bool) *StepDir {
+	return &StepDir{driver: driver, step: step, dir: dir, dedge: dedge, microsteps: driver.Microsteps()}
+}
+
+// sync rescales the position after the microstep resolution was changed with SetMicrosteps
+func (sd *StepDir) sync() {
+	if n := sd.driver.Microsteps(); n != sd.microsteps {
+		sd.position = int32(divRoundSigned(int64(sd.position)*int64(n), int64(sd.microsteps)))
+		sd.microsteps = n
+	}
+}
+
+// Configure sets up the STEP and DIR pins and writes the microstep resolution of the Stepper, interpolation
+// and the step edge mode into CHOPCONF, keeping the other chopper settings. SD_MODE is a pin of the chip
+// and must be tied high in hardware.
+func (sd *StepDir) Configure() error {
+	mres, err := microstepsToMres(sd.driver.Microsteps())
+	if err != nil {
+		return err
+	}
+	chopconf := NewCHOPCONF()
+	chopconf.Unpack(sd.driver.shadowValue(CHOPCONF))
+	chopconf.Mres = mres
+	chopconf.Intpol = true
+	chopconf.Dedge = sd.dedge
+	if err = sd.driver.WriteRegister(CHOPCONF, chopconf.Pack()); err != nil {
+		return err
+	}
+	sd.step.Configure(machine.PinConfig{Mode: machine.PinOutput})
+	sd.dir.Configure(machine.PinConfig{Mode: machine.PinOutput})
+	sd.step.Low()
+	return nil
+}
+
+// SetInvertDirection swaps the level of DIR for the positive direction
+func (sd *StepDir) SetInvertDirection(invert bool) {
+	sd.invertDir = invert
+}
+
+// SetSpeed sets the maximum speed in units/s
+func (sd *StepDir) SetSpeed(speed float32) error {
+	if speed <= 0 {
+		return CustomError("speed must be greater than 0")
+	}
+	sd.speed = speed
+	return nil
+}
+
+// SetAcceleration sets the acceleration and deceleration in units/s², 0 moves at constant speed
+func (sd *StepDir) SetAcceleration(acceleration float32) error {
+	if acceleration < 0 {
+		return CustomError("acceleration must not be negative")
+	}
+	sd.acceleration = acceleration
+	return nil
+}
+
+// Position returns the position in axis units, counted from the pulses sent
+func (sd *StepDir) Position() float32 {
+	sd.sync()
+	return sd.driver.axis.FromMicrosteps(sd.position)
+}
+
+// SetPosition redefines the current position in axis units
+func (sd *StepDir) SetPosition(position float32) {
+	sd.microsteps = sd.driver.Microsteps()
+	sd.position = sd.driver.axis.ToMicrosteps(position)
+}
+
+// MoveTo moves to an absolute position in axis units with a trapezoidal profile and returns when it is reached.
+// The pulses are timed by busy waiting on the calling goroutine, so interrupts and other goroutines add jitter.
+// Closing done decelerates to standstill and returns ErrWaitCanceled; the position stays correct.
+func (sd *StepDir) MoveTo(position float32, done <-chan struct{}) error {
+	sd.sync()
+	return sd.move(sd.driver.axis.ToMicrosteps(position)-sd.position, done)
+}
+
+// MoveBy moves by a distance in axis units relative to the current position, see MoveTo
+func (sd *StepDir) MoveBy(delta float32, done <-chan struct{}) error {
+	return sd.move(sd.driver.axis.ToMicrosteps(delta), done)
+}
+
+// move sends delta microsteps
+func (sd *StepDir) move(delta int32, done <-chan struct{}) error {
+	if sd.speed <= 0 {
+		return CustomError("speed not set")
+	}
+	if delta == 0 {
+		return nil
+	}
+	sd.sync()
+	direction := int32(1)
+	if delta < 0 {
+		direction = -1
+		delta = -delta
+	}
+	sd.dir.Set((direction > 0) != sd.invertDir)
+	microsteps := sd.driver.axis.ToSteps(1) * sd.driver.stepper.microsteps()
+	profile := stepProfile{
+		vmax:  sd.speed * microsteps,
+		accel: sd.acceleration * microsteps,
+		total: delta,
+	}
+
+	canceled := false
+	next := time.Now().Add(stepPulseWidth) // DIR setup time
+	for i := int32(0); i < profile.total; i++ {
+		if !canceled {
+			select {
+			case <-done:
+				canceled = true
+				profile.stop(i)
+				if i >= profile.total {
+					return ErrWaitCanceled
+				}
+			default:
+			}
+		}
+		waitUntil(next)
+		sd.pulse()
+		sd.position += direction
+		next = next.Add(profile.interval(i))
+	}
+	if canceled {
+		return ErrWaitCanceled
+	}
+	return nil
+}
+
+// pulse sends one step on STEP
+func (sd *StepDir) pulse() {
+	if sd.dedge {
+		sd.step.Set(!sd.step.Get())
+		return
+	}
+	sd.step.High()
+	waitUntil(time.Now().Add(stepPulseWidth))
+	sd.step.Low()
+}
+
+// waitUntil busy waits, as time.Sleep is too coarse for step pulses on most targets
+func waitUntil(deadline time.Time) {
+	for time.Now().Before(deadline) {
+	}
+}
+
+// stepProfile plans the time between microsteps of a trapezoidal move
+type stepProfile struct {
+	vmax  float32 // Microsteps/s
+	accel float32 // Microsteps/s², 0 = constant speed
+	total int32   // Microsteps of the move
+}
+
+// velocity returns the velocity in microsteps/s after microstep i, limited by the acceleration from the start
+// and the deceleration to the end of the move
+func (profile stepProfile) velocity(i int32) float32 {
+	if profile.accel <= 0 {
+		return profile.vmax
+	}
+	accelerating := tinymath.Sqrt(2 * profile.accel * float32(i+1))
+	decelerating := tinymath.Sqrt(2 * profile.accel * float32(profile.total-i))
+	return tinymath.Min(profile.vmax, tinymath.Min(accelerating, decelerating))
+}
+
+// interval returns the time from microstep i to the next one
+func (profile stepProfile) interval(i int32) time.Duration {
+	return time.Duration(float32(time.Second) / profile.velocity(i))
+}
+
+// stop shortens the move to decelerate to standstill before microstep i, without passing the original end
+func (profile *stepProfile) stop(i int32) {
+	profile.total = min(profile.total, i+profile.stopSteps(i))
+}
+
+// stopSteps returns the microsteps needed to decelerate to standstill before microstep i
+func (profile stepProfile) stopSteps(i int32) int32 {
+	if profile.accel <= 0 || i == 0 {
+		return 0
+	}
+	v := profile.velocity(i - 1)
+	return int32(v * v / (2 * profile.accel))
+}
@@ -37,14 +37,15 @@ const (
 	StepAngle_0_48 = 0.48
 
 	// Common microstepping options
-	Step_1   uint8 = 1
-	Step_2   uint8 = 2
-	Step_4   uint8 = 4
-	Step_8   uint8 = 8
-	Step_16  uint8 = 16
-	Step_32  uint8 = 32
-	Step_64  uint8 = 64
-	Step_128 uint8 = 128
+	Step_1   uint16 = 1
+	Step_2   uint16 = 2
+	Step_4   uint16 = 4
+	Step_8   uint16 = 8
+	Step_16  uint16 = 16
+	Step_32  uint16 = 32
+	Step_64  uint16 = 64
+	Step_128 uint16 = 128
+	Step_256 uint16 = 256
 )
 
 const (
@@ -68,8 +69,8 @@ type Stepper struct {
 	LCoil       float32
 	IPeak       float32
 	RSense      float32
-	MSteps      uint8
-	Fclk        uint8 //Clock in Mhz
+	MSteps      uint16 // Microsteps per full step (1..256), see Driver.SetMicrosteps
+	Fclk        uint8  //Clock in Mhz
 
 }
 
@@ -89,7 +90,7 @@ func NewDefaultStepper() Stepper {
 }
 
 // NewStepper initializes a Stepper with user-defined values
-func NewStepper(angle float32, gearRatio, vSupply, rCoil, lCoil, iPeak, rSense float32, mSteps uint8, fclk uint8) Stepper {
+func NewStepper(angle float32, gearRatio, vSupply, rCoil, lCoil, iPeak, rSense float32, mSteps uint16, fclk uint8) Stepper {
 	return Stepper{
 		Angle:     angle,     // User-defined stepper angle (e.g., StepAngle_1_8)
 		GearRatio: gearRatio, // User-defined gear ratio
@@ -0,0 +1,82 @@
+package tmc5160
+
+// ModeThresholds sets the velocities at which the driver changes its chopper and current control modes.
+// Velocities are in units/s of the Driver's Axis (motor full steps/s unless SetAxis was called); 0 disables a threshold.
+type ModeThresholds struct {
+	StealthChop float32 // Upper velocity for stealthChop, above it spreadCycle is used (TPWMTHRS, 0 = stealthChop at all velocities)
+	CoolStep    float32 // Lower velocity for coolStep and the stallGuard2 stall output (TCOOLTHRS, 0 = off)
+	High        float32 // Velocity above which coolStep and stallGuard2 are off and the high velocity modes apply (THIGH)
+	Fullstep    bool    // Switch to fullstep above High (CHOPCONF.Vhighfs)
+	ConstantOff bool    // Switch to the constant off time chopper with fast decay disabled above High (CHOPCONF.Vhighchm)
+}
+
+// thresholdToTSTEP converts a threshold velocity into TSTEP units, keeping 0 as "disabled"
+func (driver *Driver) thresholdToTSTEP(v float32) uint32 {
+	if v == 0 {
+		return 0
+	}
+	return driver.axis.VelocityToTSTEP(v)
+}
+
+// SetModeThresholds writes TPWMTHRS, TCOOLTHRS, THIGH and the CHOPCONF high velocity switches.
+// The enabled thresholds must be ordered StealthChop <= CoolStep < High: coolStep only works in spreadCycle,
+// and the high velocity modes end the coolStep range.
+func (driver *Driver) SetModeThresholds(thresholds ModeThresholds) error {
+	if thresholds.StealthChop < 0 || thresholds.CoolStep < 0 || thresholds.High < 0 {
+		return CustomError("threshold velocities must not be negative")
+	}
+	if thresholds.StealthChop != 0 && thresholds.CoolStep != 0 && thresholds.StealthChop > thresholds.CoolStep {
+		return CustomError("stealthChop threshold above coolStep threshold")
+	}
+	if thresholds.High != 0 && thresholds.StealthChop >= thresholds.High {
+		return CustomError("stealthChop threshold not below high velocity threshold")
+	}
+	if thresholds.High != 0 && thresholds.CoolStep >= thresholds.High {
+		return CustomError("coolStep threshold not below high velocity threshold")
+	}
+	if thresholds.High == 0 && (thresholds.Fullstep || thresholds.ConstantOff) {
+		return CustomError("high velocity modes need a high velocity threshold")
+	}
+
+	tpwmthrs := NewPWMTHRS()
+	tpwmthrs.Value = driver.thresholdToTSTEP(thresholds.StealthChop)
+	if err := driver.WriteRegister(TPWMTHRS, tpwmthrs.Pack()); err != nil {
+		return err
+	}
+	tcoolthrs := NewTCOOLTHRS()
+	tcoolthrs.Value = driver.thresholdToTSTEP(thresholds.CoolStep)
+	if err := driver.WriteRegister(TCOOLTHRS, tcoolthrs.Pack()); err != nil {
+		return err
+	}
+	thigh := NewTHIGH()
+	thigh.Value = driver.thresholdToTSTEP(thresholds.High)
+	if err := driver.WriteRegister(THIGH, thigh.Pack()); err != nil {
+		return err
+	}
+	chopconf := NewCHOPCONF()
+	chopconf.Unpack(driver.shadowValue(CHOPCONF))
+	chopconf.Vhighfs = thresholds.Fullstep
+	chopconf.Vhighchm = thresholds.ConstantOff
+	return driver.WriteRegister(CHOPCONF, chopconf.Pack())
+}
+
+// ModeThresholds returns the thresholds last written with SetModeThresholds
+func (driver *Driver) ModeThresholds() ModeThresholds {
+	chopconf := NewCHOPCONF()
+	chopconf.Unpack(driver.shadowValue(CHOPCONF))
+	return ModeThresholds{
+		StealthChop: driver.tstepToThreshold(driver.shadowValue(TPWMTHRS)),
+		CoolStep:    driver.tstepToThreshold(driver.shadowValue(TCOOLTHRS)),
+		High:        driver.tstepToThreshold(driver.shadowValue(THIGH)),
+		Fullstep:    chopconf.Vhighfs,
+		ConstantOff: chopconf.Vhighchm,
+	}
+}
+
+// tstepToThreshold converts a threshold register value into a velocity in units/s, keeping 0 as "disabled"
+func (driver *Driver) tstepToThreshold(tstep uint32) float32 {
+	if tstep == 0 {
+		return 0
+	}
+	return driver.axis.FromSteps(driver.stepper.TSTEPToVelocity(tstep))
+}
@@ -2,20 +2,46 @@ package tmc5160
 
 import "machine"
 
+// Driver controls one TMC5160. It is not safe for concurrent use: register accesses and the state kept
+// for write-only registers are not synchronised, so all calls must come from the same goroutine.
+// Supervision such as CheckEncoder is done with single-step Check functions called between motion commands.
 type Driver struct {
 	comm      RegisterComm
 	address   uint8
 	enablePin machine.Pin
 	stepper   Stepper
+	ramp      RampProfile      // Last ramp written to the Driver
+	axis      Axis             // Mechanical model used by the motion functions
+	rampMode  RampMode         // Last RAMPMODE written to the Driver
+	rampDirty bool             // VSTART and AMAX were replaced by a hard stop
+	shadow    map[uint8]uint32 // Last value written to each register, as many are write-only
+
+	fault       HealthStatus    // Faults SuperviseFaults gave up on
+	faultConfig []savedRegister // Power stage configuration before the permanent fault
+
+	position      int32            // Last XACTUAL read or written in microsteps, restored after a reset
+	positionKnown bool             // position was read at rest after the last motion command
+	onReset       func(ResetEvent) // Called after a reset was detected and the configuration restored
 }
 
+// NewDriver creates a Driver for the chip at address on comm.
+// enablePin is wired to ENN (active low): it is configured as an output and driven low to enable the power stage,
+// and fault recovery drives it high while the stage is switched off. Pass machine.NoPin if ENN is tied in hardware;
+// pin 0 is a real GPIO on most targets.
 func NewDriver(comm RegisterComm, address uint8, enablePin machine.Pin, stepper Stepper) *Driver {
-	return &Driver{
+	if enablePin != machine.NoPin {
+		enablePin.Configure(machine.PinConfig{Mode: machine.PinOutput})
+		enablePin.Low()
+	}
+	driver := &Driver{
 		comm:      comm,
 		address:   address,
 		enablePin: enablePin,
 		stepper:   stepper,
+		shadow:    make(map[uint8]uint32),
 	}
+	driver.axis = Axis{Stepper: &driver.stepper} // Motor full steps until SetAxis is called
+	return driver
 }
 
 // WriteRegister sends a register write command to the Driver.
@@ -24,7 +50,15 @@ func (driver *Driver) WriteRegister(reg uint8, value uint32) error {
 		return CustomError("communication interface not set")
 	}
 	// Use the communication interface (RegisterComm) to write the register
-	return driver.comm.WriteRegister(reg, value, driver.address)
+	err := driver.comm.WriteRegister(reg, value, driver.address)
+	if err != nil {
+		return err
+	}
+	if driver.shadow == nil {
+		driver.shadow = make(map[uint8]uint32) // Driver not created with NewDriver
+	}
+	driver.shadow[reg] = value
+	return nil
 }
 
 // ReadRegister sends a register read command to the Driver and returns the read value.
@@ -66,7 +100,7 @@ func (driver *Driver) Begin(powerParams PowerStageParameters, motorParams MotorP
 	// Set initial currents and delay
 	iholdrun := NewIHOLD_IRUN()
 	iholdrun.Ihold = constrain(motorParams.ihold, 0, 31)
-	iholdrun.Ihold = constrain(motorParams.irun, 0, 31)
+	iholdrun.Irun = constrain(motorParams.irun, 0, 31)
 	iholdrun.IholdDelay = 7
 	err = driver.WriteRegister(IHOLD_IRUN, iholdrun.Pack())
 	if err != nil {
@@ -108,13 +142,20 @@ func (driver *Driver) Begin(powerParams PowerStageParameters, motorParams MotorP
 	_chopConf.Tbl = 2
 	_chopConf.HstrtTfd = 4
 	_chopConf.HendOffset = 0
+	_chopConf.Mres, err = microstepsToMres(uint16(driver.stepper.microsteps()))
+	if err != nil {
+		return false
+	}
+	_chopConf.Intpol = true // Interpolate to 256 microsteps
 	err = driver.WriteRegister(CHOPCONF, _chopConf.Pack())
 	if err != nil {
 		return false
 	}
-	rampMode := NewRAMPMODE(driver.comm, driver.address)
 	// Use position mode
-	rampMode.SetMode(PositioningMode)
+	err = driver.setRampMode(PositioningMode)
+	if err != nil {
+		return false
+	}
 
 	// Set StealthChop PWM mode and shaft direction
 	gconf := NewGCONF()
@@ -126,7 +167,10 @@ func (driver *Driver) Begin(powerParams PowerStageParameters, motorParams MotorP
 	}
 
 	// Set default start, stop, threshold speeds
-	setRampSpeeds(0.0, 0.1, 0.0) // Start, stop, threshold speeds
+	err = driver.setRampSpeeds(0.0, 0.1, 0.0) // Start, stop, threshold speeds
+	if err != nil {
+		return false
+	}
 
 	// Set default D1 (must not be = 0 in positioning mode even with V1=0)
 	err = driver.WriteRegister(D_1, 100)
@@ -134,19 +178,7 @@ func (driver *Driver) Begin(powerParams PowerStageParameters, motorParams MotorP
 		return false
 	}
 
-	return false
-}
-
-func setRampSpeeds(startSpeed float32, stopSpeed float32, transitionSpeed float32) {
-	//TODO
-	println("Ramp set to: startSpeed:", startSpeed, "stopSpeed:", stopSpeed, "transitionSpeed:", transitionSpeed)
-}
-
-// setMaxSpeed sets the maximum speed to 0 (placeholder function)
-func setMaxSpeed(speed uint32) {
-	// This is a placeholder function that sets the speed
-	// Implement the actual logic to set the maximum speed register value
-	println("Max Speed set to:", speed)
+	return true
 }
 
 // Dump_TMC reads multiple registers from the Driver and logs their values with their names.
@@ -0,0 +1,162 @@
+package tmc5160
+
+import (
+	"time"
+)
+
+// DefaultPollInterval is the RAMP_STAT polling interval used when WaitOptions.Interval is not set
+const DefaultPollInterval = 10 * time.Millisecond
+
+// Errors returned by the wait functions
+const (
+	ErrWaitTimeout    = CustomError("wait timed out")
+	ErrWaitCanceled   = CustomError("wait canceled")
+	ErrStopLeft       = CustomError("left stop switch event")
+	ErrStopRight      = CustomError("right stop switch event")
+	ErrStallGuardStop = CustomError("stallGuard2 stop event")
+	ErrDriverFault    = CustomError("driver error")
+	ErrChipReset      = CustomError("driver reset")
+)
+
+// RAMP_STAT flags that are cleared by writing 1
+const rampStatEventMask = 1<<2 | 1<<3 | 1<<6 | 1<<7 | 1<<12
+
+// WaitOptions configures how the wait functions poll the Driver
+type WaitOptions struct {
+	Interval time.Duration   // Polling interval (0 = DefaultPollInterval)
+	Timeout  time.Duration   // Give up after this time (0 = wait forever)
+	Done     <-chan struct{} // Optional cancellation, e.g. ctx.Done()
+}
+
+// MotionError reports a stop or fault event that ended a wait before the motion completed
+type MotionError struct {
+	Event    CustomError // ErrStopLeft, ErrStopRight, ErrStallGuardStop, ErrDriverFault or ErrChipReset
+	RampStat uint32      // RAMP_STAT at the time of the event
+}
+
+func (e *MotionError) Error() string {
+	return e.Event.Error()
+}
+
+// Unwrap allows errors.Is(err, ErrStallGuardStop) and similar checks
+func (e *MotionError) Unwrap() error {
+	return e.Event
+}
+
+// WaitPositionReached waits until XACTUAL equals XTARGET
+func (driver *Driver) WaitPositionReached(opts WaitOptions) error {
+	return driver.waitFor(func(r *RAMP_STAT_Register) bool { return r.PositionReached }, opts)
+}
+
+// WaitVelocityReached waits until VACTUAL equals VMAX
+func (driver *Driver) WaitVelocityReached(opts WaitOptions) error {
+	return driver.waitFor(func(r *RAMP_STAT_Register) bool { return r.VelocityReached }, opts)
+}
+
+// WaitStandstill waits until the ramp generator velocity is 0
+func (driver *Driver) WaitStandstill(opts WaitOptions) error {
+	return driver.waitFor(func(r *RAMP_STAT_Register) bool { return r.VZero }, opts)
+}
+
+// ClearRampEvents clears the latched event flags in RAMP_STAT.
+// A stallGuard2 stop keeps the motor blocked until its event is cleared.
+func (driver *Driver) ClearRampEvents() error {
+	return driver.WriteRegister(RAMP_STAT, rampStatEventMask)
+}
+
+// poll calls step every opts.Interval until it returns true or an error. It returns ErrWaitTimeout when
+// the timeout expires and ErrWaitCanceled when opts.Done is closed. step runs once before the first wait.
+func poll(opts WaitOptions, step func() (bool, error)) error {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	var timeout <-chan time.Time
+	if opts.Timeout > 0 {
+		timer := time.NewTimer(opts.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		done, err := step()
+		if err != nil || done {
+			return err
+		}
+		select {
+		case <-opts.Done:
+			return ErrWaitCanceled
+		case <-timeout:
+			return ErrWaitTimeout
+		case <-time.After(interval):
+		}
+	}
+}
+
+// waitFor polls RAMP_STAT until done returns true, a stop or fault event occurs, the timeout expires or opts.Done is closed
+func (driver *Driver) waitFor(done func(*RAMP_STAT_Register) bool, opts WaitOptions) error {
+	rampStat := NewRAMP_STAT()
+	return poll(opts, func() (bool, error) {
+		value, err := driver.ReadRegister(RAMP_STAT)
+		if err != nil {
+			return false, err
+		}
+		rampStat.Unpack(value)
+		if err = driver.checkMotionEvents(rampStat, value); err != nil {
+			return false, err
+		}
+		if !done(rampStat) {
+			return false, nil
+		}
+		if rampStat.PositionReached || rampStat.VZero {
+			// The motor is at rest, remember where for the reset recovery
+			xactual, err := driver.ReadRegister(XACTUAL)
+			if err != nil {
+				return false, err
+			}
+			driver.rememberPosition(XACTUALToMicrosteps(xactual))
+		}
+		return true, nil
+	})
+}
+
+// checkMotionEvents returns a MotionError for stop switch, stallGuard2, driver error and reset events.
+// After a reset the configuration is restored before the error is returned.
+func (driver *Driver) checkMotionEvents(rampStat *RAMP_STAT_Register, value uint32) error {
+	switch {
+	case rampStat.EventStopSG:
+		return &MotionError{Event: ErrStallGuardStop, RampStat: value}
+	case rampStat.EventStopL:
+		return &MotionError{Event: ErrStopLeft, RampStat: value}
+	case rampStat.EventStopR:
+		return &MotionError{Event: ErrStopRight, RampStat: value}
+	}
+	fault, reset, err := driver.globalStatus()
+	if err != nil {
+		return err
+	}
+	if reset {
+		if err = driver.handleReset(); err != nil {
+			return err
+		}
+		return &MotionError{Event: ErrChipReset, RampStat: value}
+	}
+	if fault {
+		return &MotionError{Event: ErrDriverFault, RampStat: value}
+	}
+	return nil
+}
+
+// globalStatus reports the GSTAT drv_err and reset flags, taken from the SPI status byte when the interface provides it
+func (driver *Driver) globalStatus() (fault bool, reset bool, err error) {
+	if status, ok := driver.comm.(StatusComm); ok {
+		flags := status.Status(driver.address)
+		return flags&SPIStatusDriverError != 0, flags&SPIStatusResetFlag != 0, nil
+	}
+	value, err := driver.ReadRegister(GSTAT)
+	if err != nil {
+		return false, false, err
+	}
+	gstat := NewGSTAT()
+	gstat.Unpack(value)
+	return gstat.DrvErr, gstat.Reset, nil
+}